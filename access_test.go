@@ -0,0 +1,58 @@
+package cachemap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddWithAccessFuncExisting(t *testing.T) {
+	m, err := New(Option{AddOverwrites: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	var first, second int
+	if err := m.AddWithAccessFunc("k", 1, time.Hour, nil, func(CacheItem) { first++ }); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddWithAccessFunc("k", 2, time.Hour, nil, func(CacheItem) { second++ }); err != nil {
+		t.Fatal(err)
+	}
+	item, err := m.Get("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Value != 2 {
+		t.Errorf("value: got %v, want 2", item.Value)
+	}
+	if first != 1 || second != 0 {
+		t.Errorf("access funcs: got %d, %d, want 1, 0", first, second)
+	}
+}
+
+func TestGetExpiredCountsAsMiss(t *testing.T) {
+	var misses []interface{}
+	m := NewCacheMap(Option{
+		SleepTime: time.Hour,
+		OnMiss:    func(key interface{}) { misses = append(misses, key) },
+	})
+	defer m.Stop()
+	accessed := 0
+	if err := m.AddWithAccessFunc("k", 1, time.Nanosecond, nil, func(CacheItem) { accessed++ }); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := m.Get("k"); err != nil {
+		t.Fatal(err)
+	}
+	if len(misses) != 1 || misses[0] != "k" {
+		t.Errorf("OnMiss: got %v, want [k]", misses)
+	}
+	if accessed != 0 {
+		t.Errorf("access func called %d times for an expired entry", accessed)
+	}
+	m.Get("absent")
+	if len(misses) != 2 {
+		t.Errorf("OnMiss for absent key: got %v", misses)
+	}
+}
@@ -0,0 +1,112 @@
+package cachemap
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 将别名解析为主键, 调用方需持有锁
+func (cm *cacheMap) resolveLocked(key, k interface{}) (interface{}, interface{}) {
+	p, ok := cm.aliases[k]
+	if !ok {
+		return key, k
+	}
+	return cm.m[p].Key, p
+}
+
+// 删除键值对并移除指向它的所有别名, 依赖它的键值对随之过期, 调用方需持有写锁
+// 依赖被删除键值对的键值对会随之过期, 因此调用方需在释放写锁前调用 flushBatchLocked 交给 BatchCallback
+func (cm *cacheMap) removeLocked(k interface{}) {
+	delete(cm.m, k)
+	for _, a := range cm.aliasesOf[k] {
+		delete(cm.aliases, a)
+	}
+	delete(cm.aliasesOf, k)
+	cm.dropDependentsLocked(k)
+}
+
+// 移除单个别名, 调用方需持有写锁
+func (cm *cacheMap) unaliasLocked(ak interface{}) {
+	p, ok := cm.aliases[ak]
+	if !ok {
+		return
+	}
+	delete(cm.aliases, ak)
+	list := cm.aliasesOf[p]
+	for i, a := range list {
+		if a == ak {
+			list = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(cm.aliasesOf, p)
+	} else {
+		cm.aliasesOf[p] = list
+	}
+}
+
+func (cm *cacheMap) alias(existingKey, aliasKey interface{}) error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	_, k, err := cm.writeKey(existingKey)
+	if err != nil {
+		return err
+	}
+	_, ak, err := cm.rawKey(aliasKey)
+	if err != nil {
+		return err
+	}
+	if _, ok := cm.liveLocked(k, cm.now()); !ok {
+		return errors.New(ErrorKeyNotFound)
+	}
+	if _, ok := cm.m[ak]; ok || ak == k {
+		return errors.New(ErrorKeyExist)
+	}
+	cm.unaliasLocked(ak)
+	if cm.aliases == nil {
+		cm.aliases = make(map[interface{}]interface{})
+		cm.aliasesOf = make(map[interface{}][]interface{})
+	}
+	cm.aliases[ak] = k
+	cm.aliasesOf[k] = append(cm.aliasesOf[k], ak)
+	if f := cm.loadFilter(); f != nil {
+		f.add(ak)
+	}
+	return nil
+}
+
+// 使 aliasKey 指向 existingKey 对应的键值对, 之后所有以 aliasKey 进行的操作都作用于该键值对
+// existingKey 本身是别名时指向其主键, aliasKey 已是别名时改为指向新的键值对, 已是普通键时返回错误
+// 键值对被删除或过期时, 指向它的所有别名随之移除, 别名不计入 Len 也不会被遍历
+func (w *Map) Alias(existingKey, aliasKey interface{}) error {
+	return w.alias(existingKey, aliasKey)
+}
+
+// 检查别名索引是否互为逆映射, 别名指向存在的主键且不与普通键冲突, 调用方需持有锁
+func (cm *cacheMap) checkAliasesLocked() error {
+	for a, k := range cm.aliases {
+		if _, ok := cm.m[k]; !ok {
+			return fmt.Errorf("alias %v targets missing key %v", a, k)
+		}
+		if _, ok := cm.m[a]; ok {
+			return fmt.Errorf("alias %v is also a key", a)
+		}
+	}
+	count := 0
+	for k, list := range cm.aliasesOf {
+		if len(list) == 0 {
+			return fmt.Errorf("key %v has an empty alias list", k)
+		}
+		for _, a := range list {
+			if p, ok := cm.aliases[a]; !ok || p != k {
+				return fmt.Errorf("alias %v of %v targets %v", a, k, p)
+			}
+		}
+		count += len(list)
+	}
+	if count != len(cm.aliases) {
+		return fmt.Errorf("%d aliases indexed by key but %d by alias", count, len(cm.aliases))
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package cachemap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAliasInvariants(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	for _, k := range []string{"a", "b"} {
+		if err := m.Add(k, k, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Alias("a", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Alias("x", "y"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	// 已是别名时改为指向新的键值对
+	if err := m.Alias("b", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if item, err := m.Get("x"); err != nil || item.Value != "b" {
+		t.Errorf("Get via moved alias: got %v, %v, want b", item.Value, err)
+	}
+	if err := m.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Del("a"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Has("y") {
+		t.Error("alias still resolves after its key was deleted")
+	}
+	if err := m.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	m.cacheMap.aliases["z"] = "missing"
+	if err := m.CheckInvariants(); err == nil {
+		t.Error("CheckInvariants: dangling alias not reported")
+	}
+}
@@ -0,0 +1,84 @@
+package cachemap
+
+import (
+	"fmt"
+	"hash/maphash"
+	"sync/atomic"
+)
+
+const (
+	bloomHashes      = 4
+	bloomBitsPerKey  = 10
+	bloomMinimumBits = 1024
+)
+
+// 用于快速判断键一定不存在的布隆过滤器
+// 写入在 Map 的写锁内进行, 查询不持有锁, 因此位数组通过原子操作读写
+type bloomFilter struct {
+	seed maphash.Seed
+	bits []uint64
+}
+
+func newBloomFilter(keys int) *bloomFilter {
+	n := keys * bloomBitsPerKey
+	if n < bloomMinimumBits {
+		n = bloomMinimumBits
+	}
+	return &bloomFilter{
+		seed: maphash.MakeSeed(),
+		bits: make([]uint64, (n+63)/64),
+	}
+}
+
+func (f *bloomFilter) hash(k interface{}) (uint64, uint64) {
+	var h maphash.Hash
+	h.SetSeed(f.seed)
+	if s, ok := k.(string); ok {
+		h.WriteString(s)
+	} else {
+		fmt.Fprintf(&h, "%T:%v", k, k)
+	}
+	sum := h.Sum64()
+	return sum, sum>>32 | 1
+}
+
+// 调用方需持有 Map 的写锁
+func (f *bloomFilter) add(k interface{}) {
+	h1, h2 := f.hash(k)
+	n := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % n
+		p := &f.bits[bit/64]
+		atomic.StoreUint64(p, atomic.LoadUint64(p)|1<<(bit%64))
+	}
+}
+
+// 返回 false 时键一定不存在
+func (f *bloomFilter) mayContain(k interface{}) bool {
+	h1, h2 := f.hash(k)
+	n := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % n
+		if atomic.LoadUint64(&f.bits[bit/64])&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (cm *cacheMap) loadFilter() *bloomFilter {
+	f, _ := cm.filter.Load().(*bloomFilter)
+	return f
+}
+
+// 按当前的键重建过滤器, 以清除已删除的键, 调用方需持有写锁
+func (cm *cacheMap) rebuildFilterLocked() {
+	f := newBloomFilter(len(cm.m) + len(cm.aliases))
+	for k := range cm.m {
+		f.add(k)
+	}
+	for a := range cm.aliases {
+		f.add(a)
+	}
+	cm.filter.Store(f)
+}
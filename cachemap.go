@@ -1,15 +1,23 @@
 package cachemap
 
 import (
+	"container/heap"
 	"errors"
 	"fmt"
+	"math/rand"
+	"path"
 	"reflect"
+	"regexp"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type CacheMap = *cacheMapWrapper
+// 为兼容保留的别名, 新代码可直接使用 *Map 或 Cache 接口
+type CacheMap = *Map
 
 type CallFuncType func(item CacheItem)
 
@@ -18,148 +26,978 @@ type CacheItem struct {
 	Value      interface{}
 	TTL        time.Duration
 	UpdateTime time.Time
-	callFunc   CallFuncType
+	CreateTime time.Time
+	// 设置了 ValueHash 时为值的哈希, 可用于 GetIfChanged
+	Hash     string
+	callFunc CallFuncType
+	// Get 成功读取时在锁外调用
+	accessFunc CallFuncType
+	// managed 为 true 时到期只调用唤醒函数而不删除, notified 记录本次到期是否已通知
+	managed  bool
+	notified bool
+	// 到期前 warnLead 时调用一次 warnFunc, warned 记录本次是否已调用
+	warnLead time.Duration
+	warnFunc CallFuncType
+	warned   bool
+	// 插入序号, 覆盖值时保持不变
+	seq uint64
+	// WithLock 使用的键值对级别的锁, 首次使用时创建
+	mu *sync.Mutex
 }
 
 type cacheMap struct {
+	// 原子访问的 64 位字段放在最前以保证 32 位平台上的对齐
+	rejectedValues uint64
+
 	m          map[interface{}]*CacheItem
 	lock       sync.RWMutex
 	stopChan   chan struct{}
-	stopStatus bool
+	stopStatus int32
+	paused     int32
+	done       chan struct{}
+	stopOnce   sync.Once
 	sleepTime  time.Duration
+
+	tombstones        map[interface{}]time.Time
+	tombstoneDuration time.Duration
+	poolItems         bool
+	addOverwrites     bool
+	addRefreshes      bool
+	addRefreshesTTL   bool
+	sweepStartJitter  time.Duration
+	sweeperPool       *SweeperPool
+	keyFunc           func(key interface{}) interface{}
+	closed            bool
+	nextSeq           uint64
+	validator         func(key, value interface{}) error
+	onExpire          CallFuncType
+	globalExpireMode  GlobalExpireMode
+	batchCallback     func(items []CacheItem)
+	batchCallbackOnly bool
+	batch             []CacheItem
+	ttlFunc           func(key, value interface{}) time.Duration
+	// 存放 *bloomFilter, 未启用 EnableNegativeFilter 时为空
+	filter       atomic.Value
+	peak         int
+	compactRatio float64
+	onMiss       func(key interface{})
+
+	keyCanonicalizer func(key interface{}) interface{}
+	maxValueBytes    int64
+	sizer            func(value interface{}) int64
+	onValueTooLarge  func(key interface{}, size int64)
+	forbidNilValues  bool
+	codec            Codec
+	deterministic    bool
+	coarseClock      time.Duration
+	copyBytes        bool
+	valueHash        func(value interface{}) string
+	// 存放 time.Time, 仅在启用 CoarseClock 时使用
+	clock atomic.Value
+	// 别名到主键以及主键到别名的索引, 均为实际存储用的键, 首次调用 Alias 时创建
+	aliases   map[interface{}]interface{}
+	aliasesOf map[interface{}][]interface{}
+	// 父键到依赖它的键以及依赖键到父键的索引, 首次调用 AddDependent 时创建
+	dependents map[interface{}][]interface{}
+	dependsOn  map[interface{}]interface{}
+	// 持有锁时正在执行的用户函数数量, 原子访问, 用于检测在其中调用 StopAndWait
+	callbackDepth int32
 }
 
-type cacheMapWrapper struct {
+// Cache Map, 通过 New 或 NewCacheMap 创建
+type Map struct {
 	*cacheMap
 }
 
 type Option struct {
 	SleepTime time.Duration
+	// Del 后保留墓碑的时长, 期间 WasRecentlyDeleted 返回 true, 为 0 时不保留
+	TombstoneDuration time.Duration
+	// 创建时立即同步清理一次过期键值对
+	ImmediateSweep bool
+	// 通过 sync.Pool 复用被删除或过期的 CacheItem, 以减少高频增删时的内存分配
+	PoolItems bool
+	// Add 遇到已存在的键时按 Set 的方式覆盖, 而不是返回 ErrorKeyExist
+	AddOverwrites bool
+	// Add 遇到已存在的键时只重置其 UpdateTime 而不修改值, 用于心跳等场景, 不能与 AddOverwrites 同时使用
+	AddRefreshesExisting bool
+	// 与 AddRefreshesExisting 一起使用, 同时将 TTL 更新为 Add 传入的 TTL
+	AddRefreshesTTL bool
+	// 首次清理前额外等待 [0, SweepStartJitter) 内的随机时长, 避免大量 Map 同时清理
+	SweepStartJitter time.Duration
+	// 使用共享的清理协程池, 而不是为每个 Map 单独启动清理协程
+	SweeperPool *SweeperPool
+	// 由调用方传入的键计算实际存储用的键, 用于支持 []byte 等不可比较的键
+	// 设置后不再调用 CheckKeyType 检查键, CacheItem.Key 仍为调用方传入的原始键
+	KeyFunc func(key interface{}) interface{}
+	// 写入值之前的校验函数, 返回错误时放弃写入, 在锁外调用
+	// Merge 还会在写锁内校验合并后的值, HSet 的字段值, PushToList 的元素, IncrWindow 的计数和导入的值不经过校验
+	Validator func(key, value interface{}) error
+	// 全局过期回调, 调用时机由 GlobalExpireMode 决定
+	OnExpire         CallFuncType
+	GlobalExpireMode GlobalExpireMode
+	// 一次清理或 Clear 中移除的所有键值对会一并传给 BatchCallback, 按到期时间 (UpdateTime+TTL) 升序排列
+	// 传入的切片会被复用, BatchCallback 返回后不得继续持有
+	BatchCallback func(items []CacheItem)
+	// 设置后过期时不再调用键值对自身的唤醒函数, 只调用 BatchCallback
+	BatchCallbackOnly bool
+	// 根据键和值计算 TTL, 设置后 Add/Set 等传入的 TTL 将被其结果替代
+	TTLFunc func(key, value interface{}) time.Duration
+	// 启用布隆过滤器, Get 不存在的键时无需加锁即可返回, 过滤器在每轮清理时重建
+	EnableNegativeFilter bool
+	// 清理后键值对数量低于峰值的 CompactRatio 倍时自动执行 Compact, 为 0 时不自动执行
+	CompactRatio float64
+	// Get 或 GetDetailed 未命中 (键不存在或已过期) 时调用, 在锁外调用
+	OnMiss func(key interface{})
+	// 在所有操作查找或插入之前对键进行规范化, 使逻辑上相同的键对应同一个键值对
+	// 必须是快速的纯函数, 只能在创建时设置, CacheItem.Key 保存的是规范化后的键
+	KeyCanonicalizer func(key interface{}) interface{}
+	// 单个值的大小上限, 超过时写入返回 ErrorValueTooLarge, 为 0 时不限制
+	// Merge, HSet, PushToList 和导入检查的是合并后实际存储的值, 列表和字段集合按整体计算
+	MaxValueBytes int64
+	// 计算值的大小, 未设置时 string 和 []byte 按长度计算, 其他类型视为 0, 需同时设置 MaxValueBytes
+	// 列表以 []ListElem 传入, 字段集合以 map[interface{}]interface{} 传入
+	Sizer func(value interface{}) int64
+	// 值因超过 MaxValueBytes 被拒绝时调用, 可用于记录出问题的键, 与 Validator 一样在锁外调用
+	// 检查合并后的值时 Sizer 和 OnValueTooLarge 在写锁内调用, 不能调用 Map 的方法
+	OnValueTooLarge func(key interface{}, size int64)
+	// 写入 nil 值时返回 ErrorNilValue, 只检查无类型的 nil, (*T)(nil) 等有类型的空值不受影响
+	// 同样适用于 Merge 合并出的值, HSet 的字段值和 PushToList 的元素
+	// 未设置时允许 nil 值, nil 值与其他值一样会原样返回, 并在 Has 和 Len 中视为存在
+	ForbidNilValues bool
+	// 使用 Codec 将值编码为字节后存储, 以 CPU 换取更少的内存占用和 GC 扫描
+	// 所有读取操作和回调看到的都是解码后的值, 只有 GetRef 和 GetOrAddPointer 看到编码后的字节
+	// SerializeValues 和 Codec 需在同一个 Option 中同时设置
+	SerializeValues bool
+	Codec           Codec
+	// Foreach 和 ForeachBatch 按键排序后遍历, 先比较 fmt.Sprint 的结果, 相同时比较类型名
+	// 遍历结果可复现, 便于测试和调试输出, 但每次遍历都需要排序
+	DeterministicIteration bool
+	// 使用后台协程按该精度更新的时间代替 time.Now, 以降低 Get 等高频操作的开销
+	// 判断过期和记录 UpdateTime 时最多有一个精度周期的误差, 为 0 时直接使用 time.Now
+	CoarseClock time.Duration
+	// 写入 []byte 值时保存其副本, Get 等读取时也返回副本, 避免调用方修改切片后影响缓存的内容
+	// 所有读取操作和回调得到的都是副本, 只有 GetRef 和 GetOrAddPointer 返回缓存内部的切片
+	CopyBytes bool
+	// 写入值时计算其哈希并保存在 CacheItem.Hash 中, 在写锁内调用, 启用 SerializeValues 时传入解码后的值
+	ValueHash func(value interface{}) string
+}
+
+// OnExpire 的调用方式, 键值对自身的唤醒函数在任何模式下都会被调用
+type GlobalExpireMode int
+
+const (
+	// 每个键值对过期时都调用 OnExpire
+	GlobalExpireAlways GlobalExpireMode = iota
+	// 仅在键值对没有唤醒函数时调用 OnExpire
+	GlobalExpireOnlyWithoutItemFunc
+	// 仅在键值对有唤醒函数时, 在其之后补充调用 OnExpire
+	GlobalExpireSupplement
+)
+
+var itemPool = sync.Pool{
+	New: func() interface{} {
+		return new(CacheItem)
+	},
 }
 
 const (
 	ErrorInvalidKeyType = "invalid key type"
 	ErrorKeyNotFound    = "key not found"
 	ErrorKeyExist       = "key exist"
+	ErrorInvalidOption  = "invalid option"
+	ErrorClosed         = "cache map closed"
+	ErrorWrongType      = "wrong value type"
+	ErrorValueTooLarge  = "value too large"
+	ErrorNilValue       = "nil value"
+)
+
+// GetDetailed 返回的查询状态
+type Status int
+
+const (
+	StatusFound Status = iota
+	StatusExpired
+	StatusAbsent
+	StatusInvalidKey
 )
 
-type cacheMapInterface interface {
+func (s Status) String() string {
+	switch s {
+	case StatusFound:
+		return "found"
+	case StatusExpired:
+		return "expired"
+	case StatusAbsent:
+		return "absent"
+	case StatusInvalidKey:
+		return "invalid key"
+	}
+	return fmt.Sprintf("status(%d)", int(s))
+}
+
+// Cache Map 的核心接口, 供其他实现 (如测试替身 cachemaptest.Fake) 实现
+//
+// 所有实现都应满足以下一致性保证, 前三条可通过 cachemaptest.RunConformance 检查:
+//   - Add, SetValue 等写入返回后, 任何协程之后调用的 Get, Has 和 Len 都能看到该写入
+//   - Del 返回后, 任何协程之后调用的 Get 和 Has 都看不到该键值对, Clear 返回后 Len 为 0
+//   - Foreach 看到的是一致的快照: 开始前存在的键值对各访问恰好一次, 遍历期间的写入等待遍历结束后才生效
+//   - 唤醒函数看到的是键值对被移除时的最终值
+type Cache interface {
 	Add(key, value interface{}, ttl time.Duration, callFunc CallFuncType) error
 	Del(key interface{}) error
 	Get(key interface{}) (CacheItem, error)
+	Has(key interface{}) bool
+	Len() int
 	SetValue(key, value interface{}) error
 	SetTTL(key interface{}, ttl time.Duration, resetUpdateTime bool) error
 	SetCallFunc(key interface{}, callFunc CallFuncType) error
-	Foreach(fn func(item CacheItem))
+	Foreach(fn CallFuncType)
+	Clear()
 	Stop()
 }
 
+var _ Cache = (CacheMap)(nil)
+
 func (cm *cacheMap) cacheRun() {
+	defer close(cm.done)
+	if cm.sweepStartJitter > 0 {
+		select {
+		case <-cm.stopChan:
+			return
+		case <-time.After(time.Duration(rand.Int63n(int64(cm.sweepStartJitter)))):
+		}
+	}
 	for {
 		select {
 		case <-cm.stopChan:
 			return
 		case <-time.After(cm.sleepTime):
-			cm.lock.Lock()
-			for k, v := range cm.m {
-				if v.TTL > 0 && v.UpdateTime.Add(v.TTL).Before(time.Now()) {
-					if v.callFunc != nil {
-						v.callFunc(*v)
-					}
-					delete(cm.m, k)
-				}
+			cm.tick()
+		}
+	}
+}
+
+// 定时清理, 暂停期间跳过
+func (cm *cacheMap) tick() {
+	if atomic.LoadInt32(&cm.paused) == 0 {
+		cm.sweep()
+	}
+}
+
+// 清理过期键值对, 每轮清理只在开始时取一次当前时间, 所有键值对都以该时间判断是否过期
+// 停止后会在处理下一个键值对前中止本轮清理, 以免 Stop 后仍长时间持有锁
+func (cm *cacheMap) sweep() {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	if cm.stopped() {
+		return
+	}
+	now := cm.now()
+	for k, v := range cm.m {
+		if cm.stopped() {
+			break
+		}
+		if cm.stateAt(k, v, now) == EntryExpired || v.emptyListAt(now) {
+			cm.expireLocked(k, v)
+			continue
+		}
+		if v.TTL <= 0 {
+			continue
+		}
+		warn := v.warnFunc != nil && !v.warned && !now.Before(v.deadline().Add(-v.warnLead))
+		notify := !v.notified && v.StateAt(now) == EntryStale
+		if !warn && !notify {
+			continue
+		}
+		if warn {
+			cm.runCallback(v.warnFunc, cm.view(v))
+		}
+		if notify && v.callFunc != nil {
+			cm.runCallback(v.callFunc, cm.view(v))
+		}
+		n := cm.cloneItem(v)
+		n.warned = n.warned || warn
+		n.notified = n.notified || notify
+		cm.m[k] = n
+	}
+	cm.flushBatchLocked()
+	for k, t := range cm.tombstones {
+		if now.Sub(t) >= cm.tombstoneDuration {
+			delete(cm.tombstones, k)
+		}
+	}
+	if cm.compactRatio > 0 && float64(len(cm.m)) < float64(cm.peak)*cm.compactRatio {
+		cm.compactLocked()
+	}
+	if cm.loadFilter() != nil {
+		cm.rebuildFilterLocked()
+	}
+}
+
+// 删除已过期的键值对并调用其唤醒函数, 调用方需持有写锁
+func (cm *cacheMap) expireLocked(k interface{}, item *CacheItem) {
+	if item.callFunc != nil && !cm.batchCallbackOnly {
+		cm.runCallback(item.callFunc, cm.view(item))
+	}
+	if cm.onExpire != nil {
+		switch cm.globalExpireMode {
+		case GlobalExpireAlways:
+			cm.runCallback(cm.onExpire, cm.view(item))
+		case GlobalExpireOnlyWithoutItemFunc:
+			if item.callFunc == nil {
+				cm.runCallback(cm.onExpire, cm.view(item))
 			}
-			cm.lock.Unlock()
+		case GlobalExpireSupplement:
+			if item.callFunc != nil {
+				cm.runCallback(cm.onExpire, cm.view(item))
+			}
+		}
+	}
+	if cm.batchCallback != nil {
+		cm.batch = append(cm.batch, cm.view(item))
+	}
+	cm.removeLocked(k)
+	cm.releaseItem(item)
+}
+
+// 将本次操作中移除的键值对一并传给 BatchCallback, 调用方需持有写锁
+func (cm *cacheMap) flushBatchLocked() {
+	if len(cm.batch) == 0 {
+		return
+	}
+	sort.Stable((*byDeadline)(&cm.batch))
+	cm.enterCallback()
+	cm.batchCallback(cm.batch)
+	cm.leaveCallback()
+	// 复用缓冲区, 清空已复制的键值对以免其值在过期后仍无法被回收
+	for i := range cm.batch {
+		cm.batch[i] = CacheItem{}
+	}
+	cm.batch = cm.batch[:0]
+}
+
+// 按到期时间升序排列, 以指针传给 sort.Stable, 避免每轮清理排序时分配内存
+type byDeadline []CacheItem
+
+func (s *byDeadline) Len() int           { return len(*s) }
+func (s *byDeadline) Less(i, j int) bool { return (*s)[i].deadline().Before((*s)[j].deadline()) }
+func (s *byDeadline) Swap(i, j int)      { (*s)[i], (*s)[j] = (*s)[j], (*s)[i] }
+
+// 在持有锁时调用用户函数前后调用, 期间 StopAndWait 只停止而不等待
+func (cm *cacheMap) enterCallback() {
+	atomic.AddInt32(&cm.callbackDepth, 1)
+}
+
+func (cm *cacheMap) leaveCallback() {
+	atomic.AddInt32(&cm.callbackDepth, -1)
+}
+
+// 调用单个键值对的回调, 调用方需持有写锁
+func (cm *cacheMap) runCallback(f CallFuncType, item CacheItem) {
+	cm.enterCallback()
+	defer cm.leaveCallback()
+	f(item)
+}
+
+// 键存在但已过期时按过期处理并返回 false, 调用方需持有写锁
+func (cm *cacheMap) liveLocked(k interface{}, now time.Time) (*CacheItem, bool) {
+	item, ok := cm.m[k]
+	if !ok {
+		return nil, false
+	}
+	if cm.stateAt(k, item, now) == EntryExpired {
+		cm.expireLocked(k, item)
+		cm.flushBatchLocked()
+		return nil, false
+	}
+	return item, true
+}
+
+// 插入新的键值对, 调用方需持有写锁
+func (cm *cacheMap) insertLocked(k interface{}, item *CacheItem) {
+	cm.m[k] = item
+	if len(cm.m) > cm.peak {
+		cm.peak = len(cm.m)
+	}
+	delete(cm.tombstones, k)
+	if f := cm.loadFilter(); f != nil {
+		f.add(k)
+	}
+}
+
+func (cm *cacheMap) allocItem() *CacheItem {
+	if cm.poolItems {
+		return itemPool.Get().(*CacheItem)
+	}
+	return new(CacheItem)
+}
+
+// 设置键值对的值, 设置了 ValueHash 时同时更新哈希, 调用方需持有写锁
+func (cm *cacheMap) assign(item *CacheItem, value interface{}) {
+	item.Value = value
+	if cm.valueHash != nil {
+		if v, err := cm.decodeValue(value); err == nil {
+			item.Hash = cm.valueHash(v)
 		}
 	}
 }
 
+func (cm *cacheMap) newItem(key, value interface{}, ttl time.Duration, callFunc CallFuncType) *CacheItem {
+	item := cm.allocItem()
+	now := cm.now()
+	item.Key = key
+	cm.assign(item, value)
+	item.TTL = ttl
+	item.UpdateTime = now
+	item.CreateTime = now
+	item.callFunc = callFunc
+	cm.nextSeq++
+	item.seq = cm.nextSeq
+	return item
+}
+
+// 复制一个 CacheItem, 修改操作均在副本上进行后替换 Map 中的指针, 不会修改可能被其他地方引用的原结构体
+// 副本包含唤醒函数等全部未导出字段, SetValue/SetTTL 等修改操作依赖这一点保留原有唤醒函数
+func (cm *cacheMap) cloneItem(item *CacheItem) *CacheItem {
+	n := cm.allocItem()
+	*n = *item
+	return n
+}
+
+// 回收已从 Map 中移除的 CacheItem, 调用方需保证唤醒函数已执行完毕且不再持有该指针
+func (cm *cacheMap) releaseItem(item *CacheItem) {
+	if !cm.poolItems {
+		return
+	}
+	*item = CacheItem{}
+	itemPool.Put(item)
+}
+
 func newCacheMap() *cacheMap {
 	cm := &cacheMap{
 		m:          make(map[interface{}]*CacheItem),
 		lock:       sync.RWMutex{},
 		stopChan:   make(chan struct{}),
-		stopStatus: false,
+		done:       make(chan struct{}),
 		sleepTime:  800 * time.Millisecond,
+		tombstones: make(map[interface{}]time.Time),
 	}
 	return cm
 }
 
-//停止运行
-func (w *cacheMapWrapper) Stop() {
-	w.stopChan <- struct{}{}
-	w.stopStatus = true
-	close(w.stopChan)
+func (cm *cacheMap) stopped() bool {
+	return atomic.LoadInt32(&cm.stopStatus) == 1
+}
+
+// 只设置停止标志并关闭 stopChan, 不等待正在进行的清理, 也不需要获取锁
+func (cm *cacheMap) stop() {
+	cm.stopOnce.Do(func() {
+		atomic.StoreInt32(&cm.stopStatus, 1)
+		if cm.sweeperPool != nil {
+			cm.sweeperPool.unregister(cm)
+		}
+		close(cm.stopChan)
+	})
+}
+
+//停止运行, 可重复调用, 不等待正在进行的清理结束
+func (w *Map) Stop() {
+	runtime.SetFinalizer(w, nil)
+	w.stop()
+}
+
+// 停止运行并等待正在进行的清理中止以及清理协程退出
+// 唤醒函数, OnExpire, BatchCallback 以及 Foreach, GroupBy, GetGrouped, ForeachMatching, Transaction,
+// SnapshotAndReset, GetOrAddPointer 和 Merge 的函数参数都在持有锁时调用, 在其中等待会死锁
+// 因此只要有这样的函数正在执行就只停止而不等待, 由于无法区分协程, 此时其他协程调用也不会等待
+func (w *Map) StopAndWait() {
+	w.Stop()
+	if atomic.LoadInt32(&w.callbackDepth) > 0 {
+		return
+	}
+	if w.sweeperPool == nil {
+		<-w.done
+	}
+	w.lock.Lock()
+	w.lock.Unlock()
+}
+
+// 只输出地址和运行状态而不输出内容, 可在回调内安全调用, 也不会因值引用自身而无限递归
+func (w *Map) String() string {
+	state := "running"
+	switch {
+	case w.stopped():
+		state = "stopped"
+	case atomic.LoadInt32(&w.paused) != 0:
+		state = "paused"
+	}
+	return fmt.Sprintf("cachemap.Map(%p, %s)", w.cacheMap, state)
 }
 
 // 创建一个 Cache Map
 func NewCacheMap(options ...Option) CacheMap {
-	w := &cacheMapWrapper{newCacheMap()}
+	w := &Map{newCacheMap()}
+	immediateSweep := false
 	if len(options) > 0 {
 		for _, v := range options {
 			if v.SleepTime > 0 {
 				w.sleepTime = v.SleepTime
 			}
+			if v.TombstoneDuration > 0 {
+				w.tombstoneDuration = v.TombstoneDuration
+			}
+			if v.ImmediateSweep {
+				immediateSweep = true
+			}
+			if v.PoolItems {
+				w.poolItems = true
+			}
+			if v.AddOverwrites {
+				w.addOverwrites = true
+			}
+			if v.AddRefreshesExisting {
+				w.addRefreshes = true
+				w.addRefreshesTTL = v.AddRefreshesTTL
+			}
+			if v.SweepStartJitter > 0 {
+				w.sweepStartJitter = v.SweepStartJitter
+			}
+			if v.SweeperPool != nil {
+				w.sweeperPool = v.SweeperPool
+			}
+			if v.KeyFunc != nil {
+				w.keyFunc = v.KeyFunc
+			}
+			if v.Validator != nil {
+				w.validator = v.Validator
+			}
+			if v.OnExpire != nil {
+				w.onExpire = v.OnExpire
+				w.globalExpireMode = v.GlobalExpireMode
+			}
+			if v.BatchCallback != nil {
+				w.batchCallback = v.BatchCallback
+				w.batchCallbackOnly = v.BatchCallbackOnly
+			}
+			if v.TTLFunc != nil {
+				w.ttlFunc = v.TTLFunc
+			}
+			if v.EnableNegativeFilter && w.loadFilter() == nil {
+				w.rebuildFilterLocked()
+			}
+			if v.CompactRatio > 0 {
+				w.compactRatio = v.CompactRatio
+			}
+			if v.OnMiss != nil {
+				w.onMiss = v.OnMiss
+			}
+			if v.KeyCanonicalizer != nil {
+				w.keyCanonicalizer = v.KeyCanonicalizer
+			}
+			if v.MaxValueBytes > 0 {
+				w.maxValueBytes = v.MaxValueBytes
+			}
+			if v.Sizer != nil {
+				w.sizer = v.Sizer
+			}
+			if v.OnValueTooLarge != nil {
+				w.onValueTooLarge = v.OnValueTooLarge
+			}
+			if v.ForbidNilValues {
+				w.forbidNilValues = true
+			}
+			if v.SerializeValues && v.Codec != nil {
+				w.codec = v.Codec
+			}
+			if v.DeterministicIteration {
+				w.deterministic = true
+			}
+			if v.CoarseClock > 0 {
+				w.coarseClock = v.CoarseClock
+			}
+			if v.CopyBytes {
+				w.copyBytes = true
+			}
+			if v.ValueHash != nil {
+				w.valueHash = v.ValueHash
+			}
 		}
 	}
-	go w.cacheRun()
-	runtime.SetFinalizer(w, (*cacheMapWrapper).Stop)
+	if w.coarseClock > 0 {
+		w.clock.Store(time.Now())
+		go w.runClock()
+	}
+	if immediateSweep {
+		w.sweep()
+	}
+	if w.sweeperPool != nil {
+		w.sweeperPool.register(w.cacheMap)
+	} else {
+		go w.cacheRun()
+	}
+	runtime.SetFinalizer(w, func(w *Map) {
+		w.stop()
+	})
 	return w
 }
 
+// 创建一个 Cache Map, 与 NewCacheMap 不同的是会校验配置并对无效或矛盾的配置返回错误
+func New(options ...Option) (CacheMap, error) {
+	if err := checkOptions(options); err != nil {
+		return nil, err
+	}
+	return NewCacheMap(options...), nil
+}
+
+func checkOptions(options []Option) error {
+	var pool *SweeperPool
+	overwrites, refreshes := false, false
+	sized, limited := false, false
+	for _, v := range options {
+		switch {
+		case v.SleepTime < 0:
+			return errors.New(ErrorInvalidOption + ": SleepTime must not be negative")
+		case v.TombstoneDuration < 0:
+			return errors.New(ErrorInvalidOption + ": TombstoneDuration must not be negative")
+		case v.SweepStartJitter < 0:
+			return errors.New(ErrorInvalidOption + ": SweepStartJitter must not be negative")
+		case v.GlobalExpireMode != GlobalExpireAlways && v.OnExpire == nil:
+			return errors.New(ErrorInvalidOption + ": GlobalExpireMode set without OnExpire")
+		case v.GlobalExpireMode < GlobalExpireAlways || v.GlobalExpireMode > GlobalExpireSupplement:
+			return errors.New(ErrorInvalidOption + ": unknown GlobalExpireMode")
+		case v.CompactRatio < 0 || v.CompactRatio >= 1:
+			return errors.New(ErrorInvalidOption + ": CompactRatio must be in [0, 1)")
+		case v.AddRefreshesTTL && !v.AddRefreshesExisting:
+			return errors.New(ErrorInvalidOption + ": AddRefreshesTTL set without AddRefreshesExisting")
+		case v.BatchCallbackOnly && v.BatchCallback == nil:
+			return errors.New(ErrorInvalidOption + ": BatchCallbackOnly set without BatchCallback")
+		case v.MaxValueBytes < 0:
+			return errors.New(ErrorInvalidOption + ": MaxValueBytes must not be negative")
+		case v.CoarseClock < 0:
+			return errors.New(ErrorInvalidOption + ": CoarseClock must not be negative")
+		case v.SerializeValues && v.Codec == nil:
+			return errors.New(ErrorInvalidOption + ": SerializeValues set without Codec")
+		case v.Codec != nil && !v.SerializeValues:
+			return errors.New(ErrorInvalidOption + ": Codec set without SerializeValues")
+		}
+		if v.SweeperPool != nil {
+			if pool != nil && pool != v.SweeperPool {
+				return errors.New(ErrorInvalidOption + ": multiple SweeperPool given")
+			}
+			pool = v.SweeperPool
+		}
+		overwrites = overwrites || v.AddOverwrites
+		refreshes = refreshes || v.AddRefreshesExisting
+		sized = sized || v.Sizer != nil || v.OnValueTooLarge != nil
+		limited = limited || v.MaxValueBytes > 0
+	}
+	if overwrites && refreshes {
+		return errors.New(ErrorInvalidOption + ": AddRefreshesExisting conflicts with AddOverwrites")
+	}
+	if sized && !limited {
+		return errors.New(ErrorInvalidOption + ": Sizer or OnValueTooLarge set without MaxValueBytes")
+	}
+	return nil
+}
+
+// 检查键能否作为 map 的键, 结构体字段, 数组元素和接口中的动态值也会被检查
+// 返回键的类型, 键无效时返回其中无法比较的部分的类型
 func CheckKeyType(key interface{}) (string, bool) {
-	Kind := reflect.ValueOf(key).Kind()
-	switch {
-	case Kind == reflect.Map:
-		return Kind.String(), false
-	case Kind == reflect.Slice:
-		return Kind.String(), false
-	case Kind == reflect.Func:
+	return checkKeyValue(reflect.ValueOf(key))
+}
+
+func checkKeyValue(v reflect.Value) (string, bool) {
+	Kind := v.Kind()
+	switch Kind {
+	case reflect.Map, reflect.Slice, reflect.Func:
 		return Kind.String(), false
+	case reflect.Interface:
+		if !v.IsNil() {
+			if tp, ok := checkKeyValue(v.Elem()); !ok {
+				return tp, false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if tp, ok := checkKeyValue(v.Field(i)); !ok {
+				return tp, false
+			}
+		}
+	case reflect.Array:
+		// 元素为基本类型时无需逐个检查, 长度为 0 时也需根据元素类型判断
+		switch elem := v.Type().Elem().Kind(); elem {
+		case reflect.Map, reflect.Slice, reflect.Func:
+			return elem.String(), false
+		case reflect.Interface, reflect.Struct, reflect.Array:
+		default:
+			return Kind.String(), true
+		}
+		for i := 0; i < v.Len(); i++ {
+			if tp, ok := checkKeyValue(v.Index(i)); !ok {
+				return tp, false
+			}
+		}
 	}
 	return Kind.String(), true
 }
 
-func (cm *cacheMap) add(key, value interface{}, ttl time.Duration, callFunc CallFuncType) error {
+// 检查键并返回规范化后的键和实际存储用的键, 键为别名时返回其主键, 调用方需持有锁
+func (cm *cacheMap) mapKey(key interface{}) (interface{}, interface{}, error) {
+	key, k, err := cm.rawKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, k = cm.resolveLocked(key, k)
+	return key, k, nil
+}
+
+// 同 mapKey, 但不解析别名, 无需持有锁
+func (cm *cacheMap) rawKey(key interface{}) (interface{}, interface{}, error) {
+	if cm.keyCanonicalizer != nil {
+		key = cm.keyCanonicalizer(key)
+	}
+	if cm.keyFunc != nil {
+		return key, cm.keyFunc(key), nil
+	}
+	if tp, ok := CheckKeyType(key); !ok {
+		return nil, nil, errors.New(fmt.Sprintf(ErrorInvalidKeyType+": %s", tp))
+	}
+	return key, key, nil
+}
+
+// 同 mapKey, 但在 Shutdown 之后返回错误, 用于所有写入操作
+func (cm *cacheMap) writeKey(key interface{}) (interface{}, interface{}, error) {
+	if cm.closed {
+		return nil, nil, errors.New(ErrorClosed)
+	}
+	return cm.mapKey(key)
+}
+
+// 写入前检查值
+func (cm *cacheMap) checkValue(key, value interface{}) error {
+	if err := cm.checkNil(value); err != nil {
+		return err
+	}
+	if err := cm.validate(key, value); err != nil {
+		return err
+	}
+	return cm.checkSize(key, value)
+}
+
+func (cm *cacheMap) checkNil(value interface{}) error {
+	if cm.forbidNilValues && value == nil {
+		return errors.New(ErrorNilValue)
+	}
+	return nil
+}
+
+func (cm *cacheMap) validate(key, value interface{}) error {
+	if cm.validator != nil {
+		if err := cm.validator(key, value); err != nil {
+			return fmt.Errorf("key %v: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (cm *cacheMap) checkSize(key, value interface{}) error {
+	if cm.maxValueBytes > 0 {
+		if size := cm.sizeOf(value); size > cm.maxValueBytes {
+			atomic.AddUint64(&cm.rejectedValues, 1)
+			if cm.onValueTooLarge != nil {
+				cm.onValueTooLarge(key, size)
+			}
+			return errors.New(fmt.Sprintf(ErrorValueTooLarge+": %d > %d", size, cm.maxValueBytes))
+		}
+	}
+	return nil
+}
+
+// 检查在写锁内合并出的实际存储的值, 用于 Merge, HSet, PushToList 和导入, 此时 Sizer 和 OnValueTooLarge 在写锁内调用
+func (cm *cacheMap) checkSizeLocked(key, value interface{}) error {
+	if cm.maxValueBytes <= 0 {
+		return nil
+	}
+	cm.enterCallback()
+	defer cm.leaveCallback()
+	return cm.checkSize(key, value)
+}
+
+// 在写锁内检查 Merge 合并出的值, Validator 和大小检查均在写锁内调用
+func (cm *cacheMap) checkMergedLocked(key, value interface{}) error {
+	if cm.validator == nil && cm.maxValueBytes <= 0 {
+		return nil
+	}
+	cm.enterCallback()
+	defer cm.leaveCallback()
+	if err := cm.validate(key, value); err != nil {
+		return err
+	}
+	return cm.checkSize(key, value)
+}
+
+// 因超过 MaxValueBytes 被拒绝写入的次数
+func (w *Map) RejectedValues() uint64 {
+	return atomic.LoadUint64(&w.rejectedValues)
+}
+
+// 计算值的大小, 用于 MaxValueBytes, 列表和字段集合以 []ListElem 和 map[interface{}]interface{} 的形式计算
+func (cm *cacheMap) sizeOf(value interface{}) int64 {
+	switch v := value.(type) {
+	case listValue:
+		value = []ListElem(v)
+	case hashValue:
+		value = map[interface{}]interface{}(v)
+	}
+	if cm.sizer != nil {
+		return cm.sizer(value)
+	}
+	return defaultSize(value)
+}
+
+// string 和 []byte 按长度计算, 列表和字段集合为其中元素, 字段名和字段值之和, 其他类型视为 0
+func defaultSize(value interface{}) int64 {
+	var size int64
+	switch v := value.(type) {
+	case string:
+		size = int64(len(v))
+	case []byte:
+		size = int64(len(v))
+	case []ListElem:
+		for _, e := range v {
+			size += defaultSize(e.Value)
+		}
+	case map[interface{}]interface{}:
+		for f, fv := range v {
+			size += defaultSize(f) + defaultSize(fv)
+		}
+	}
+	return size
+}
+
+// 设置了 TTLFunc 时由其计算 TTL, 否则使用传入的 TTL
+func (cm *cacheMap) ttlFor(key, value interface{}, ttl time.Duration) time.Duration {
+	if cm.ttlFunc != nil {
+		return cm.ttlFunc(key, value)
+	}
+	return ttl
+}
+
+func (cm *cacheMap) add(key, value interface{}, ttl time.Duration, callFunc, accessFunc CallFuncType) error {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
-	if tp, ok := CheckKeyType(key); !ok {
-		return errors.New(fmt.Sprintf(ErrorInvalidKeyType+": %s", tp))
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return err
 	}
-	_, ok := cm.m[key]
+	_, err = cm.addLocked(key, k, value, ttl, callFunc, accessFunc)
+	return err
+}
+
+// 按 Add 的规则写入键值对, 返回是否新建了键值对, 调用方需持有写锁
+func (cm *cacheMap) addLocked(key, k, value interface{}, ttl time.Duration, callFunc, accessFunc CallFuncType) (bool, error) {
+	now := cm.now()
+	item, ok := cm.liveLocked(k, now)
 	if !ok {
-		item := &CacheItem{
-			Key:        key,
-			Value:      value,
-			TTL:        ttl,
-			UpdateTime: time.Now(),
-			callFunc:   callFunc,
-		}
-		cm.m[key] = item
-		return nil
+		item := cm.newItem(key, value, ttl, callFunc)
+		item.accessFunc = accessFunc
+		cm.insertLocked(k, item)
+		return true, nil
+	} else if cm.addOverwrites {
+		cm.setLocked(key, k, value, ttl, callFunc, false)
+		return false, nil
+	} else if cm.addRefreshes {
+		prev := item.deadline()
+		item = cm.cloneItem(item)
+		item.UpdateTime = now
+		if cm.addRefreshesTTL {
+			item.TTL = ttl
+		}
+		item.rearm(prev)
+		cm.m[k] = item
+		return false, nil
 	} else {
-		return errors.New(ErrorKeyExist)
+		return false, errors.New(ErrorKeyExist)
+	}
+}
+
+// 添加一个键值对, 键已存在时返回错误 (设置 AddOverwrites 时等同于 Set, 设置 AddRefreshesExisting 时只刷新 UpdateTime)
+// 已过期但尚未被清理的键视为不存在, 会先调用其唤醒函数再添加
+func (w *Map) Add(key, value interface{}, ttl time.Duration, callFunc CallFuncType) error {
+	if err := w.checkValue(key, value); err != nil {
+		return err
 	}
+	ttl = w.ttlFor(key, value, ttl)
+	value, err := w.encodeValue(value)
+	if err != nil {
+		return err
+	}
+	return w.add(key, value, ttl, callFunc, nil)
+}
+
+// 同 Add, 但新建键值对时同时设置 Get 成功读取时调用的访问函数
+// 键已存在时与 Add 的处理方式相同, 覆盖或刷新已有键值对时保留其原有的访问函数
+func (w *Map) AddWithAccessFunc(key, value interface{}, ttl time.Duration, callFunc, accessFunc CallFuncType) error {
+	if err := w.checkValue(key, value); err != nil {
+		return err
+	}
+	ttl = w.ttlFor(key, value, ttl)
+	value, err := w.encodeValue(value)
+	if err != nil {
+		return err
+	}
+	return w.add(key, value, ttl, callFunc, accessFunc)
+}
+
+func (cm *cacheMap) getOrAddPointer(key interface{}, ttl time.Duration, newFn func() interface{}) interface{} {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return nil
+	}
+	if item, ok := cm.liveLocked(k, cm.now()); ok {
+		return item.Value
+	}
+	cm.enterCallback()
+	value := newFn()
+	cm.leaveCallback()
+	cm.insertLocked(k, cm.newItem(key, value, ttl, nil))
+	return value
 }
 
-// 添加一个键值对
-func (w *cacheMapWrapper) Add(key, value interface{}, ttl time.Duration, callFunc CallFuncType) error {
-	return w.add(key, value, ttl, callFunc)
+// 返回键当前存储的值, 键不存在或已过期时在写锁内调用 newFn 创建并添加, 键无效或 Map 已关闭时返回 nil
+// 同一个键的所有并发调用者得到的是同一个实例, Map 不会复制它, 适合存放 *int64 等指针并在原地累加
+// 对实例的并发修改需由调用方自行同步, newFn 不经过 Validator, TTLFunc 和 SerializeValues 处理
+func (w *Map) GetOrAddPointer(key interface{}, ttl time.Duration, newFn func() interface{}) interface{} {
+	return w.getOrAddPointer(key, ttl, newFn)
 }
 
 func (cm *cacheMap) del(key interface{}) error {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
-	if tp, ok := CheckKeyType(key); !ok {
-		return errors.New(fmt.Sprintf(ErrorInvalidKeyType+": %s", tp))
+	defer cm.flushBatchLocked()
+	return cm.delLocked(key)
+}
+
+func (cm *cacheMap) delLocked(key interface{}) error {
+	key, k, err := cm.mapKey(key)
+	if err != nil {
+		return err
 	}
-	item, ok := cm.m[key]
+	item, ok := cm.m[k]
 	if ok {
-		if item.TTL > 0 && item.UpdateTime.Add(item.TTL).Before(time.Now()) {
-			delete(cm.m, key)
+		if cm.stateAt(k, item, cm.now()) == EntryExpired {
+			cm.removeLocked(k)
+			cm.releaseItem(item)
 			return errors.New(ErrorKeyNotFound)
 		} else {
-			delete(cm.m, key)
+			cm.removeLocked(k)
+			cm.releaseItem(item)
+			if cm.tombstoneDuration > 0 {
+				cm.tombstones[k] = cm.now()
+			}
 			return nil
 		}
 	} else {
@@ -168,112 +1006,1327 @@ func (cm *cacheMap) del(key interface{}) error {
 }
 
 // 删除一个键值对
-func (w *cacheMapWrapper) Del(key interface{}) error {
+func (w *Map) Del(key interface{}) error {
 	return w.del(key)
 }
 
 func (cm *cacheMap) get(key interface{}) (CacheItem, error) {
+	if f := cm.loadFilter(); f != nil {
+		if _, k, err := cm.rawKey(key); err == nil && !f.mayContain(k) {
+			cm.miss(key)
+			return CacheItem{}, errors.New(ErrorKeyNotFound)
+		}
+	}
 	cm.lock.RLock()
-	defer cm.lock.RUnlock()
-	if tp, ok := CheckKeyType(key); !ok {
-		return CacheItem{}, errors.New(fmt.Sprintf(ErrorInvalidKeyType+": %s", tp))
+	item, state, err := cm.getStateLocked(key)
+	cm.lock.RUnlock()
+	if err != nil {
+		if err.Error() == ErrorKeyNotFound {
+			cm.miss(key)
+		}
+		return item, err
+	}
+	// 已过期但尚未被清理的键值对仍会返回, 但按未命中处理
+	if state == EntryExpired {
+		cm.miss(key)
+	} else if item.accessFunc != nil {
+		item.accessFunc(item)
+	}
+	return item, nil
+}
+
+func (cm *cacheMap) miss(key interface{}) {
+	if cm.onMiss != nil {
+		cm.onMiss(key)
+	}
+}
+
+func (cm *cacheMap) getLocked(key interface{}) (CacheItem, error) {
+	item, _, err := cm.getStateLocked(key)
+	return item, err
+}
+
+// 同 getLocked, 同时返回键值对当前的状态
+func (cm *cacheMap) getStateLocked(key interface{}) (CacheItem, EntryState, error) {
+	key, k, err := cm.mapKey(key)
+	if err != nil {
+		return CacheItem{}, EntryActive, err
 	}
-	item, ok := cm.m[key]
+	item, ok := cm.m[k]
 	if ok {
-		return *item, nil
+		value, err := cm.decodeValue(item.Value)
+		if err != nil {
+			return CacheItem{}, EntryActive, err
+		}
+		v := *item
+		v.Value = value
+		return v, cm.stateAt(k, item, cm.now()), nil
 	} else {
-		return CacheItem{}, errors.New(ErrorKeyNotFound)
+		return CacheItem{}, EntryActive, errors.New(ErrorKeyNotFound)
 	}
 }
 
-// 获取一个键值对信息
-func (w *cacheMapWrapper) Get(key interface{}) (CacheItem, error) {
+// 获取一个键值对信息, 已过期但尚未被清理的键值对仍会返回, 此时调用 OnMiss 而不调用访问函数
+// 返回的 CacheItem 是在读锁内复制的完整快照, 所有修改操作都持有写锁, 因此不会读到新旧字段混合的中间状态
+func (w *Map) Get(key interface{}) (CacheItem, error) {
 	return w.get(key)
 }
 
+// 获取一个键值对信息, 其哈希与 knownHash 相同时只返回 false 而不返回内容, 需要设置 ValueHash
+// 用于 HTTP 条件请求等场景, 键不存在时返回错误
+func (w *Map) GetIfChanged(key interface{}, knownHash string) (CacheItem, bool, error) {
+	item, err := w.get(key)
+	if err != nil {
+		return CacheItem{}, false, err
+	}
+	if knownHash != "" && item.Hash == knownHash {
+		return CacheItem{}, false, nil
+	}
+	return item, true, nil
+}
+
+// 获取 []byte 类型的值, 总是返回副本, 值不是 []byte 时返回 ErrorWrongType
+func (w *Map) GetBytes(key interface{}) ([]byte, error) {
+	item, err := w.get(key)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := item.Value.([]byte)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf(ErrorWrongType+": %T", item.Value))
+	}
+	if w.copyBytes {
+		// Get 已经返回了副本
+		return b, nil
+	}
+	return copyBytes(b), nil
+}
+
 func (cm *cacheMap) setValue(key, value interface{}) error {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
-	if tp, ok := CheckKeyType(key); !ok {
-		return errors.New(fmt.Sprintf(ErrorInvalidKeyType+": %s", tp))
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return err
 	}
-	item, ok := cm.m[key]
+	item, ok := cm.m[k]
 	if ok {
-		item.Value = value
+		item = cm.cloneItem(item)
+		cm.assign(item, value)
+		cm.m[k] = item
 		return nil
 	} else {
 		return errors.New(ErrorKeyNotFound)
 	}
 }
 
-// 设置值
-func (w *cacheMapWrapper) SetValue(key, value interface{}) error {
+// 设置值, 保留原有唤醒函数
+func (w *Map) SetValue(key, value interface{}) error {
+	if err := w.checkValue(key, value); err != nil {
+		return err
+	}
+	value, err := w.encodeValue(value)
+	if err != nil {
+		return err
+	}
 	return w.setValue(key, value)
 }
 
 func (cm *cacheMap) setTTL(key interface{}, ttl time.Duration, resetUpdateTime bool) error {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
-	if tp, ok := CheckKeyType(key); !ok {
-		return errors.New(fmt.Sprintf(ErrorInvalidKeyType+": %s", tp))
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return err
 	}
-	item, ok := cm.m[key]
+	item, ok := cm.m[k]
 	if ok {
+		prev := item.deadline()
+		item = cm.cloneItem(item)
 		item.TTL = ttl
 		if resetUpdateTime {
-			item.UpdateTime = time.Now()
+			item.UpdateTime = cm.now()
 		}
+		item.rearm(prev)
+		cm.m[k] = item
 		return nil
 	} else {
 		return errors.New(ErrorKeyNotFound)
 	}
 }
 
-//设置TTL
-func (w *cacheMapWrapper) SetTTL(key interface{}, ttl time.Duration, resetUpdateTime bool) error {
+//设置TTL, 保留原有唤醒函数, 对 AddManaged 添加的键值对会重新启用到期通知
+func (w *Map) SetTTL(key interface{}, ttl time.Duration, resetUpdateTime bool) error {
 	return w.setTTL(key, ttl, resetUpdateTime)
 }
 
 func (cm *cacheMap) setCallFunc(key interface{}, callFunc CallFuncType) error {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
-	if tp, ok := CheckKeyType(key); !ok {
-		return errors.New(fmt.Sprintf(ErrorInvalidKeyType+": %s", tp))
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return err
 	}
-	item, ok := cm.m[key]
+	item, ok := cm.m[k]
 	if ok {
+		item = cm.cloneItem(item)
 		item.callFunc = callFunc
+		cm.m[k] = item
 		return nil
 	} else {
 		return errors.New(ErrorKeyNotFound)
 	}
 }
 
-//设置唤醒函数
-func (w *cacheMapWrapper) SetCallFunc(key interface{}, callFunc CallFuncType) error {
+//设置唤醒函数, 替换原有唤醒函数
+func (w *Map) SetCallFunc(key interface{}, callFunc CallFuncType) error {
 	return w.setCallFunc(key, callFunc)
 }
 
-func (cm *cacheMap) foreach(fn CallFuncType) {
-	cm.lock.RLock()
+func (cm *cacheMap) setAccessFunc(key interface{}, accessFunc CallFuncType) error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	_, k, err := cm.writeKey(key)
+	if err != nil {
+		return err
+	}
+	item, ok := cm.m[k]
+	if ok {
+		item = cm.cloneItem(item)
+		item.accessFunc = accessFunc
+		cm.m[k] = item
+		return nil
+	} else {
+		return errors.New(ErrorKeyNotFound)
+	}
+}
+
+// 设置访问函数, Get 成功读取该键值对时在锁外调用, 替换原有访问函数, 传入 nil 时取消
+func (w *Map) SetAccessFunc(key interface{}, accessFunc CallFuncType) error {
+	return w.setAccessFunc(key, accessFunc)
+}
+
+func (cm *cacheMap) foreach(fn CallFuncType) {
+	cm.lock.RLock()
 	defer cm.lock.RUnlock()
+	cm.enterCallback()
+	defer cm.leaveCallback()
+	if cm.deterministic {
+		for _, k := range cm.sortedKeysLocked() {
+			fn(cm.view(cm.m[k]))
+		}
+		return
+	}
 	for _, v := range cm.m {
-		fn(*v)
+		fn(cm.view(v))
+	}
+}
+
+// 返回按 DeterministicIteration 规则排序的键, 调用方需持有锁
+func (cm *cacheMap) sortedKeysLocked() []interface{} {
+	type keyString struct {
+		key interface{}
+		s   string
+		tp  string
 	}
+	sorted := make([]keyString, 0, len(cm.m))
+	for k := range cm.m {
+		sorted = append(sorted, keyString{key: k, s: fmt.Sprint(k), tp: fmt.Sprintf("%T", k)})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].s != sorted[j].s {
+			return sorted[i].s < sorted[j].s
+		}
+		return sorted[i].tp < sorted[j].tp
+	})
+	keys := make([]interface{}, len(sorted))
+	for i, v := range sorted {
+		keys[i] = v.key
+	}
+	return keys
 }
 
 //遍历 Map
-func (w *cacheMapWrapper) Foreach(fn CallFuncType) {
+// 遍历期间持有读锁, fn 看到的是一致的快照, 包含已过期但尚未被清理的键值对
+func (w *Map) Foreach(fn CallFuncType) {
 	w.foreach(fn)
 }
 
+func (cm *cacheMap) foreachBatch(batchSize int, fn func(batch []CacheItem)) {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	cm.lock.RLock()
+	var keys []interface{}
+	if cm.deterministic {
+		keys = cm.sortedKeysLocked()
+	} else {
+		keys = make([]interface{}, 0, len(cm.m))
+		for k := range cm.m {
+			keys = append(keys, k)
+		}
+	}
+	cm.lock.RUnlock()
+	for len(keys) > 0 {
+		n := batchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		batch := make([]CacheItem, 0, n)
+		now := cm.now()
+		cm.lock.RLock()
+		for _, k := range keys[:n] {
+			if v, ok := cm.m[k]; ok && cm.stateAt(k, v, now) != EntryExpired {
+				batch = append(batch, cm.view(v))
+			}
+		}
+		cm.lock.RUnlock()
+		keys = keys[n:]
+		if len(batch) > 0 {
+			fn(batch)
+		}
+	}
+}
+
+// 分批遍历未过期的键值对, 每批最多 batchSize 个, 每批之间释放读锁, fn 在锁外调用
+// 开始时只记录当前的键, 不同批次之间的键值对可能已被修改或删除, 期间新增的键不会被遍历
+// 因此结果不是一致的快照, 但可以限制每次持有锁的时长
+func (w *Map) ForeachBatch(batchSize int, fn func(batch []CacheItem)) {
+	w.foreachBatch(batchSize, fn)
+}
+
 // 清除所有键值对
-func (w *cacheMapWrapper) Clear() {
+func (w *Map) Clear() {
 	w.clear()
 }
 
+func (cm *cacheMap) has(key interface{}) bool {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	key, k, err := cm.mapKey(key)
+	if err != nil {
+		return false
+	}
+	item, ok := cm.m[k]
+	return ok && cm.stateAt(k, item, cm.now()) != EntryExpired
+}
+
+// 判断键是否存在且未过期
+func (w *Map) Has(key interface{}) bool {
+	return w.has(key)
+}
+
+func (cm *cacheMap) len() int {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	return len(cm.m)
+}
+
+// 获取键值对数量, 包含已过期但尚未被清理的键值对
+func (w *Map) Len() int {
+	return w.len()
+}
+
 func (cm *cacheMap) clear() {
 	cm.lock.Lock()
 	defer cm.lock.Unlock()
+	if cm.batchCallback != nil {
+		for _, v := range cm.m {
+			cm.batch = append(cm.batch, cm.view(v))
+		}
+	}
+	cm.m = make(map[interface{}]*CacheItem)
+	cm.aliases, cm.aliasesOf = nil, nil
+	cm.dependents, cm.dependsOn = nil, nil
+	cm.peak = 0
+	cm.flushBatchLocked()
+}
+
+// 键值对状态
+type EntryState int
+
+const (
+	// 未过期
+	EntryActive EntryState = iota
+	// 已超过到期时间但不会被删除 (AddManaged 添加的键值对)
+	EntryStale
+	// 已过期, 等待清理
+	EntryExpired
+)
+
+func (s EntryState) String() string {
+	switch s {
+	case EntryActive:
+		return "active"
+	case EntryStale:
+		return "stale"
+	case EntryExpired:
+		return "expired"
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// 获取键值对在 now 时刻的状态, 包内所有过期判断均以此为准
+func (item CacheItem) StateAt(now time.Time) EntryState {
+	if item.TTL <= 0 || !item.deadline().Before(now) {
+		return EntryActive
+	}
+	if item.managed {
+		return EntryStale
+	}
+	return EntryExpired
+}
+
+// 获取键值对在 now 时刻的状态, 依赖的父键值对已过期时同样视为过期, 实际到期时间为自身与父键值对中较早的一个
+// 调用方需持有锁
+func (cm *cacheMap) stateAt(k interface{}, item *CacheItem, now time.Time) EntryState {
+	state := item.StateAt(now)
+	if state == EntryExpired {
+		return state
+	}
+	if p, ok := cm.dependsOn[k]; ok {
+		parent, ok := cm.m[p]
+		if !ok || cm.stateAt(p, parent, now) == EntryExpired {
+			return EntryExpired
+		}
+	}
+	return state
+}
+
+func (item *CacheItem) deadline() time.Time {
+	return item.UpdateTime.Add(item.TTL)
+}
+
+// 在 TTL 或 UpdateTime 被修改后调用, 到期时间延后时重新启用到期前提醒
+func (item *CacheItem) rearm(prevDeadline time.Time) {
+	item.notified = false
+	if item.deadline().After(prevDeadline) {
+		item.warned = false
+	}
+}
+
+func (cm *cacheMap) groupBy(fn func(item CacheItem) string) map[string][]CacheItem {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	cm.enterCallback()
+	defer cm.leaveCallback()
+	now := cm.now()
+	groups := make(map[string][]CacheItem)
+	for k, v := range cm.m {
+		if cm.stateAt(k, v, now) == EntryExpired {
+			continue
+		}
+		item := cm.view(v)
+		name := fn(item)
+		groups[name] = append(groups[name], item)
+	}
+	return groups
+}
+
+// 按 fn 返回的字符串对未过期的键值对分组
+func (w *Map) GroupBy(fn func(item CacheItem) string) map[string][]CacheItem {
+	return w.groupBy(fn)
+}
+
+func (cm *cacheMap) getGrouped(keys []interface{}, groupFn func(item CacheItem) string) (map[string][]CacheItem, []interface{}) {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	cm.enterCallback()
+	defer cm.leaveCallback()
+	now := cm.now()
+	groups := make(map[string][]CacheItem)
+	var misses []interface{}
+	for _, key := range keys {
+		_, k, err := cm.mapKey(key)
+		if err != nil {
+			misses = append(misses, key)
+			continue
+		}
+		v, ok := cm.m[k]
+		if !ok || cm.stateAt(k, v, now) == EntryExpired {
+			misses = append(misses, key)
+			continue
+		}
+		item := cm.view(v)
+		name := groupFn(item)
+		groups[name] = append(groups[name], item)
+	}
+	return groups, misses
+}
+
+// 在一次读锁内批量获取 keys, 将命中的键值对按 groupFn 分组, 并按原顺序返回未命中 (不存在, 已过期或无效) 的键
+// groupFn 在读锁内调用, 不会触发 OnMiss 和访问函数
+func (w *Map) GetGrouped(keys []interface{}, groupFn func(item CacheItem) string) (map[string][]CacheItem, []interface{}) {
+	return w.getGrouped(keys, groupFn)
+}
+
+func (cm *cacheMap) delPrefix(prefix string, notify bool) int {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	defer cm.flushBatchLocked()
+	now := cm.now()
+	count := 0
+	for k, v := range cm.m {
+		s, ok := v.Key.(string)
+		if !ok || !strings.HasPrefix(s, prefix) {
+			continue
+		}
+		if cm.stateAt(k, v, now) != EntryExpired {
+			count++
+			if cm.tombstoneDuration > 0 {
+				cm.tombstones[k] = now
+			}
+		}
+		if notify {
+			cm.expireLocked(k, v)
+		} else {
+			cm.removeLocked(k)
+			cm.releaseItem(v)
+		}
+	}
+	return count
+}
+
+// 删除所有以 prefix 开头的字符串键, 返回删除的数量, 非字符串键将被忽略
+// 与 Del 一样不会调用唤醒函数, 设置 TombstoneDuration 时记录墓碑
+func (w *Map) DelPrefix(prefix string) int {
+	return w.delPrefix(prefix, false)
+}
+
+// 同 DelPrefix, 但对每个被删除的键值对按过期处理, 调用其唤醒函数, OnExpire 和 BatchCallback
+func (w *Map) DelPrefixNotify(prefix string) int {
+	return w.delPrefix(prefix, true)
+}
+
+func (cm *cacheMap) foreachMatching(match func(s string) bool, fn CallFuncType) {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	cm.enterCallback()
+	defer cm.leaveCallback()
+	now := cm.now()
+	for k, v := range cm.m {
+		s, ok := v.Key.(string)
+		if !ok || cm.stateAt(k, v, now) == EntryExpired || !match(s) {
+			continue
+		}
+		fn(cm.view(v))
+	}
+}
+
+func globMatcher(pattern string) (func(s string) bool, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return func(s string) bool {
+		ok, _ := path.Match(pattern, s)
+		return ok
+	}, nil
+}
+
+// 获取所有匹配 glob 模式 (path.Match) 的字符串键, 非字符串键视为不匹配
+func (w *Map) KeysMatching(pattern string) ([]interface{}, error) {
+	match, err := globMatcher(pattern)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]interface{}, 0)
+	w.foreachMatching(match, func(item CacheItem) {
+		keys = append(keys, item.Key)
+	})
+	return keys, nil
+}
+
+// 获取所有匹配正则表达式的字符串键, 非字符串键视为不匹配
+func (w *Map) KeysMatchingRegexp(re *regexp.Regexp) []interface{} {
+	keys := make([]interface{}, 0)
+	w.foreachMatching(re.MatchString, func(item CacheItem) {
+		keys = append(keys, item.Key)
+	})
+	return keys
+}
+
+// 遍历所有键匹配 glob 模式 (path.Match) 的键值对
+func (w *Map) ForeachMatching(pattern string, fn CallFuncType) error {
+	match, err := globMatcher(pattern)
+	if err != nil {
+		return err
+	}
+	w.foreachMatching(match, fn)
+	return nil
+}
+
+// 遍历所有键匹配正则表达式的键值对
+func (w *Map) ForeachMatchingRegexp(re *regexp.Regexp, fn CallFuncType) {
+	w.foreachMatching(re.MatchString, fn)
+}
+
+// 按过期时间降序排列的堆, 堆顶为最晚过期的键值对
+type expiryHeap []*CacheItem
+
+func (h expiryHeap) Len() int            { return len(h) }
+func (h expiryHeap) Less(i, j int) bool  { return h[i].deadline().After(h[j].deadline()) }
+func (h expiryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *expiryHeap) Push(x interface{}) { *h = append(*h, x.(*CacheItem)) }
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+func (cm *cacheMap) soonestToExpire(n int) []CacheItem {
+	if n <= 0 {
+		return nil
+	}
+	cm.lock.RLock()
+	now := cm.now()
+	h := make(expiryHeap, 0, n)
+	for k, v := range cm.m {
+		if v.TTL <= 0 || cm.stateAt(k, v, now) == EntryExpired {
+			continue
+		}
+		if h.Len() < n {
+			heap.Push(&h, v)
+		} else if v.deadline().Before(h[0].deadline()) {
+			h[0] = v
+			heap.Fix(&h, 0)
+		}
+	}
+	items := make([]CacheItem, h.Len())
+	for i := len(items) - 1; i >= 0; i-- {
+		items[i] = cm.view(heap.Pop(&h).(*CacheItem))
+	}
+	cm.lock.RUnlock()
+	return items
+}
+
+// 获取最先过期的 n 个键值对 (不含 TTL 为 0 的键值对), 按过期时间升序排列
+func (w *Map) SoonestToExpire(n int) []CacheItem {
+	return w.soonestToExpire(n)
+}
+
+func (cm *cacheMap) expiringWithin(d time.Duration, fn CallFuncType) {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	now := cm.now()
+	limit := now.Add(d)
+	for k, v := range cm.m {
+		if v.TTL <= 0 || cm.stateAt(k, v, now) == EntryExpired || v.deadline().After(limit) {
+			continue
+		}
+		fn(cm.view(v))
+	}
+}
+
+// 获取将在 d 时间内过期的键值对数量
+func (w *Map) ExpiringWithin(d time.Duration) int {
+	count := 0
+	w.expiringWithin(d, func(item CacheItem) {
+		count++
+	})
+	return count
+}
+
+// 获取将在 d 时间内过期的键值对
+func (w *Map) ExpiringWithinItems(d time.Duration) []CacheItem {
+	items := make([]CacheItem, 0)
+	w.expiringWithin(d, func(item CacheItem) {
+		items = append(items, item)
+	})
+	return items
+}
+
+func (cm *cacheMap) wasRecentlyDeleted(key interface{}) bool {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	key, k, err := cm.mapKey(key)
+	if err != nil {
+		return false
+	}
+	t, ok := cm.tombstones[k]
+	return ok && cm.now().Sub(t) < cm.tombstoneDuration
+}
+
+// 判断键是否在 TombstoneDuration 内被 Del 删除
+func (w *Map) WasRecentlyDeleted(key interface{}) bool {
+	return w.wasRecentlyDeleted(key)
+}
+
+func (cm *cacheMap) flush() []CacheItem {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	now := cm.now()
+	items := make([]CacheItem, 0, len(cm.m))
+	for k, v := range cm.m {
+		if cm.stateAt(k, v, now) != EntryExpired {
+			items = append(items, cm.view(v))
+		}
+	}
 	cm.m = make(map[interface{}]*CacheItem)
+	cm.aliases, cm.aliasesOf = nil, nil
+	cm.dependents, cm.dependsOn = nil, nil
+	cm.peak = 0
+	return items
+}
+
+// 取出所有未过期的键值对并清空 Map, 不会调用唤醒函数
+func (w *Map) Flush() []CacheItem {
+	return w.flush()
+}
+
+func (cm *cacheMap) set(key, value interface{}, ttl time.Duration, callFunc CallFuncType, keepCallFunc bool) (CacheItem, bool, error) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return CacheItem{}, false, err
+	}
+	prev, replaced := cm.setLocked(key, k, value, ttl, callFunc, keepCallFunc)
+	return prev, replaced, nil
+}
+
+// 按 Set 的规则写入键值对, 返回被覆盖的未过期键值对, 调用方需持有写锁
+func (cm *cacheMap) setLocked(key, k, value interface{}, ttl time.Duration, callFunc CallFuncType, keepCallFunc bool) (CacheItem, bool) {
+	item, ok := cm.m[k]
+	if ok {
+		now := cm.now()
+		var old CacheItem
+		replaced := cm.stateAt(k, item, now) != EntryExpired
+		if replaced {
+			old = cm.view(item)
+		}
+		prev := item.deadline()
+		item = cm.cloneItem(item)
+		cm.assign(item, value)
+		item.TTL = ttl
+		item.UpdateTime = now
+		item.rearm(prev)
+		if !keepCallFunc {
+			item.callFunc = callFunc
+		}
+		cm.m[k] = item
+		return old, replaced
+	}
+	cm.insertLocked(k, cm.newItem(key, value, ttl, callFunc))
+	return CacheItem{}, false
+}
+
+// 设置键值对, 键不存在时添加, 存在时覆盖值和 TTL 并重置 UpdateTime, 替换原有唤醒函数
+// 覆盖未过期的键值对时返回其原有内容, 且 replaced 为 true
+func (w *Map) Set(key, value interface{}, ttl time.Duration, callFunc CallFuncType) (prev CacheItem, replaced bool, err error) {
+	if err := w.checkValue(key, value); err != nil {
+		return CacheItem{}, false, err
+	}
+	ttl = w.ttlFor(key, value, ttl)
+	value, err = w.encodeValue(value)
+	if err != nil {
+		return CacheItem{}, false, err
+	}
+	return w.set(key, value, ttl, callFunc, false)
+}
+
+// 同 Set, 但覆盖已存在的键时保留原有唤醒函数
+func (w *Map) SetKeepCallback(key, value interface{}, ttl time.Duration) (prev CacheItem, replaced bool, err error) {
+	if err := w.checkValue(key, value); err != nil {
+		return CacheItem{}, false, err
+	}
+	ttl = w.ttlFor(key, value, ttl)
+	value, err = w.encodeValue(value)
+	if err != nil {
+		return CacheItem{}, false, err
+	}
+	return w.set(key, value, ttl, nil, true)
+}
+
+func (cm *cacheMap) getDetailed(key interface{}) (CacheItem, Status) {
+	item, status := cm.lookupDetailed(key)
+	if status == StatusAbsent || status == StatusExpired {
+		cm.miss(key)
+	}
+	return item, status
+}
+
+func (cm *cacheMap) lookupDetailed(key interface{}) (CacheItem, Status) {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	key, k, err := cm.mapKey(key)
+	if err != nil {
+		return CacheItem{}, StatusInvalidKey
+	}
+	item, ok := cm.m[k]
+	if !ok {
+		return CacheItem{}, StatusAbsent
+	}
+	if cm.stateAt(k, item, cm.now()) == EntryExpired {
+		return cm.view(item), StatusExpired
+	}
+	return cm.view(item), StatusFound
+}
+
+// 获取一个键值对信息, 并区分已过期 (尚未被清理) 和不存在的键
+func (w *Map) GetDetailed(key interface{}) (CacheItem, Status) {
+	return w.getDetailed(key)
+}
+
+func (cm *cacheMap) popOldest() (CacheItem, bool) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	defer cm.flushBatchLocked()
+	now := cm.now()
+	var (
+		oldestKey interface{}
+		oldest    *CacheItem
+	)
+	for k, v := range cm.m {
+		if cm.stateAt(k, v, now) == EntryExpired {
+			continue
+		}
+		if oldest == nil || v.UpdateTime.Before(oldest.UpdateTime) {
+			oldestKey, oldest = k, v
+		}
+	}
+	if oldest == nil {
+		return CacheItem{}, false
+	}
+	item := cm.view(oldest)
+	cm.removeLocked(oldestKey)
+	cm.releaseItem(oldest)
+	return item, true
+}
+
+// 取出并删除 UpdateTime 最早的未过期键值对, Map 为空时返回 false
+func (w *Map) PopOldest() (CacheItem, bool) {
+	return w.popOldest()
+}
+
+func (cm *cacheMap) ageRange() (oldest, newest time.Time, ok bool) {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	now := cm.now()
+	for k, v := range cm.m {
+		if cm.stateAt(k, v, now) == EntryExpired {
+			continue
+		}
+		if !ok || v.UpdateTime.Before(oldest) {
+			oldest = v.UpdateTime
+		}
+		if !ok || v.UpdateTime.After(newest) {
+			newest = v.UpdateTime
+		}
+		ok = true
+	}
+	return oldest, newest, ok
+}
+
+// 返回未过期键值对中最早和最晚的 UpdateTime, Map 为空时 ok 为 false
+func (w *Map) AgeRange() (oldest, newest time.Time, ok bool) {
+	return w.ageRange()
+}
+
+func (cm *cacheMap) addManaged(key, value interface{}, softDeadline time.Time, callFunc CallFuncType) error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return err
+	}
+	now := cm.now()
+	if _, ok := cm.liveLocked(k, now); ok {
+		return errors.New(ErrorKeyExist)
+	}
+	ttl := softDeadline.Sub(now)
+	if ttl <= 0 {
+		// 截止时间已过, 在下一轮清理时通知
+		ttl = time.Nanosecond
+	}
+	item := cm.newItem(key, value, ttl, callFunc)
+	item.managed = true
+	cm.insertLocked(k, item)
+	return nil
+}
+
+// 添加一个由调用方管理生命周期的键值对
+// 到达 softDeadline 后只调用一次唤醒函数而不会删除, 需由调用方通过 Del 删除
+func (w *Map) AddManaged(key, value interface{}, softDeadline time.Time, callFunc CallFuncType) error {
+	if err := w.checkValue(key, value); err != nil {
+		return err
+	}
+	value, err := w.encodeValue(value)
+	if err != nil {
+		return err
+	}
+	return w.addManaged(key, value, softDeadline, callFunc)
+}
+
+func (cm *cacheMap) incrWindow(key interface{}, window time.Duration, limit int64) (int64, bool, error) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return 0, false, err
+	}
+	item, ok := cm.liveLocked(k, cm.now())
+	if !ok {
+		cm.insertLocked(k, cm.newItem(key, int64(1), window, nil))
+		return 1, 1 <= limit, nil
+	}
+	count, ok := item.Value.(int64)
+	if !ok {
+		return 0, false, errors.New(fmt.Sprintf(ErrorWrongType+": %T", item.Value))
+	}
+	count++
+	item = cm.cloneItem(item)
+	cm.assign(item, count)
+	cm.m[k] = item
+	return count, count <= limit, nil
+}
+
+// 固定窗口计数器, 窗口内首次调用时以 TTL=window 创建计数, 之后的调用只增加计数而不延长 TTL
+// 计数超过 limit 时 allowed 返回 false, 键已存在且值不是 int64 时返回错误, 计数不经过 Validator 校验
+func (w *Map) IncrWindow(key interface{}, window time.Duration, limit int64) (count int64, allowed bool, err error) {
+	return w.incrWindow(key, window, limit)
+}
+
+func (cm *cacheMap) setWarnFunc(key interface{}, lead time.Duration, fn CallFuncType) error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return err
+	}
+	item, ok := cm.m[k]
+	if !ok {
+		return errors.New(ErrorKeyNotFound)
+	}
+	item = cm.cloneItem(item)
+	item.warnLead = lead
+	item.warnFunc = fn
+	item.warned = false
+	cm.m[k] = item
+	return nil
+}
+
+// 设置到期前提醒函数, 在距离到期 lead 时间内由清理协程调用一次
+// 之后通过 SetTTL 等方式延后到期时间会重新启用提醒
+func (w *Map) SetWarnFunc(key interface{}, lead time.Duration, fn CallFuncType) error {
+	return w.setWarnFunc(key, lead, fn)
+}
+
+func (cm *cacheMap) state(key interface{}) (EntryState, error) {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	key, k, err := cm.mapKey(key)
+	if err != nil {
+		return 0, err
+	}
+	item, ok := cm.m[k]
+	if !ok {
+		return 0, errors.New(ErrorKeyNotFound)
+	}
+	return cm.stateAt(k, item, cm.now()), nil
+}
+
+// 获取键值对当前的状态
+func (w *Map) State(key interface{}) (EntryState, error) {
+	return w.state(key)
+}
+
+func (cm *cacheMap) setIfOlder(key, value interface{}, ttl, maxAge time.Duration) (bool, error) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return false, err
+	}
+	item, ok := cm.m[k]
+	if !ok {
+		cm.insertLocked(k, cm.newItem(key, value, ttl, nil))
+		return true, nil
+	}
+	now := cm.now()
+	if now.Sub(item.UpdateTime) <= maxAge {
+		return false, nil
+	}
+	prev := item.deadline()
+	item = cm.cloneItem(item)
+	cm.assign(item, value)
+	item.TTL = ttl
+	item.UpdateTime = now
+	item.rearm(prev)
+	cm.m[k] = item
+	return true, nil
+}
+
+// 仅当已有值的 UpdateTime 早于 maxAge 之前时才覆盖, 保留原有唤醒函数, 键不存在时直接添加
+// 返回是否写入
+func (w *Map) SetIfOlder(key, value interface{}, ttl, maxAge time.Duration) (bool, error) {
+	if err := w.checkValue(key, value); err != nil {
+		return false, err
+	}
+	ttl = w.ttlFor(key, value, ttl)
+	value, err := w.encodeValue(value)
+	if err != nil {
+		return false, err
+	}
+	return w.setIfOlder(key, value, ttl, maxAge)
+}
+
+// 判断两个值是否相等, 不可比较的类型视为不相等
+func valueEqual(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+func (cm *cacheMap) renewIf(key, expected interface{}, ttl time.Duration) (bool, error) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	_, k, err := cm.writeKey(key)
+	if err != nil {
+		return false, err
+	}
+	now := cm.now()
+	item, ok := cm.liveLocked(k, now)
+	if !ok || !valueEqual(cm.view(item).Value, expected) {
+		return false, nil
+	}
+	prev := item.deadline()
+	item = cm.cloneItem(item)
+	item.TTL = ttl
+	item.UpdateTime = now
+	item.rearm(prev)
+	cm.m[k] = item
+	return true, nil
+}
+
+// 仅当当前值等于 expected 时重置 UpdateTime 并设置新的 TTL, 返回是否续期
+// 键不存在或已过期时返回 false, 适用于租约续期
+func (w *Map) RenewIf(key, expected interface{}, ttl time.Duration) (bool, error) {
+	return w.renewIf(key, expected, ttl)
+}
+
+func (cm *cacheMap) getRef(key interface{}) (*CacheItem, error) {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	key, k, err := cm.mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	item, ok := cm.m[k]
+	if !ok {
+		return nil, errors.New(ErrorKeyNotFound)
+	}
+	return item, nil
+}
+
+// 获取键值对的内部指针, 避免 Get 复制 CacheItem 的开销
+// 警告: 调用方不得修改返回的 CacheItem, 也不得在之后的其他操作中继续持有它
+// 修改操作会替换 Map 中的指针, 因此返回的指针不会反映之后的修改
+// 启用 PoolItems 时, 键值对被删除或过期后该指针可能被复用为其他键值对
+// 启用 SerializeValues 时 Value 为编码后的原始数据, 不会被解码
+func (w *Map) GetRef(key interface{}) (*CacheItem, error) {
+	return w.getRef(key)
+}
+
+// 复制所有未过期的键值对
+func (cm *cacheMap) items() []CacheItem {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	now := cm.now()
+	items := make([]CacheItem, 0, len(cm.m))
+	for k, v := range cm.m {
+		if cm.stateAt(k, v, now) != EntryExpired {
+			items = append(items, cm.view(v))
+		}
+	}
+	return items
+}
+
+// 按键的字符串形式 (fmt.Sprint) 升序排列
+func ByKeyString(a, b CacheItem) bool {
+	return fmt.Sprint(a.Key) < fmt.Sprint(b.Key)
+}
+
+// 按 UpdateTime 升序排列
+func ByUpdateTime(a, b CacheItem) bool {
+	return a.UpdateTime.Before(b.UpdateTime)
+}
+
+// 按到期时间升序排列, TTL 为 0 的键值对排在最后
+func ByExpiry(a, b CacheItem) bool {
+	if a.TTL <= 0 || b.TTL <= 0 {
+		return a.TTL > 0 && b.TTL <= 0
+	}
+	return a.deadline().Before(b.deadline())
+}
+
+// 按插入顺序升序排列, 覆盖已存在的键不会改变其顺序
+func ByInsertionOrder(a, b CacheItem) bool {
+	return a.seq < b.seq
+}
+
+// 按 less 排序后遍历未过期的键值对快照, fn 返回 false 时停止遍历
+// 遍历时不持有锁, 快照之后的修改不会反映到遍历中
+func (w *Map) ForeachOrdered(less func(a, b CacheItem) bool, fn func(item CacheItem) bool) {
+	items := w.items()
+	sort.SliceStable(items, func(i, j int) bool {
+		return less(items[i], items[j])
+	})
+	for _, item := range items {
+		if !fn(item) {
+			return
+		}
+	}
+}
+
+func (cm *cacheMap) snapshotAndReset(reset func(old interface{}) interface{}) map[interface{}]interface{} {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	now := cm.now()
+	values := make(map[interface{}]interface{}, len(cm.m))
+	for k, v := range cm.m {
+		if cm.stateAt(k, v, now) == EntryExpired {
+			continue
+		}
+		old := cm.view(v).Value
+		values[k] = old
+		if cm.closed {
+			continue
+		}
+		cm.enterCallback()
+		value := reset(old)
+		cm.leaveCallback()
+		value, err := cm.encodeValue(value)
+		if err != nil {
+			continue
+		}
+		n := cm.cloneItem(v)
+		cm.assign(n, value)
+		cm.m[k] = n
+	}
+	return values
+}
+
+// 在写锁内读取所有未过期的值, 并将每个值替换为 reset(旧值), 读取和重置之间不会丢失任何修改
+// 启用 SerializeValues 时 reset 接收和返回的都是解码后的值, 重新编码失败的键值对保留原值
+// 返回的 map 以实际存储用的键为键 (设置 KeyFunc 时为 KeyFunc 的结果), Shutdown 之后只读取而不重置
+func (w *Map) SnapshotAndReset(reset func(old interface{}) interface{}) map[interface{}]interface{} {
+	return w.snapshotAndReset(reset)
+}
+
+// 按键的字符串形式 (fmt.Sprint) 排序后分页获取未过期的键, total 为未过期键的总数
+// 每次调用都会重新生成快照, 不同页之间可能因并发修改而出现重复或遗漏
+func (w *Map) KeysPage(offset, limit int) (keys []interface{}, total int) {
+	items := w.items()
+	type keyString struct {
+		key interface{}
+		s   string
+	}
+	sorted := make([]keyString, len(items))
+	for i, item := range items {
+		sorted[i] = keyString{key: item.Key, s: fmt.Sprint(item.Key)}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].s < sorted[j].s
+	})
+	total = len(sorted)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= total || limit <= 0 {
+		return []interface{}{}, total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	keys = make([]interface{}, 0, end-offset)
+	for _, v := range sorted[offset:end] {
+		keys = append(keys, v.key)
+	}
+	return keys, total
+}
+
+// 按插入顺序遍历未过期的键值对快照, 最早插入的最先遍历, fn 返回 false 时停止遍历
+func (w *Map) ForeachInOrder(fn func(item CacheItem) bool) {
+	w.ForeachOrdered(ByInsertionOrder, fn)
+}
+
+func (cm *cacheMap) checkInvariants() error {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	seqs := make(map[uint64]struct{}, len(cm.m))
+	for k, v := range cm.m {
+		if v == nil {
+			return fmt.Errorf("nil item for key %v", k)
+		}
+		if _, mk, err := cm.rawKey(v.Key); err != nil || mk != k {
+			return fmt.Errorf("item key %v does not map to its map key %v", v.Key, k)
+		}
+		if v.seq == 0 || v.seq > cm.nextSeq {
+			return fmt.Errorf("key %v has invalid insertion sequence %d", k, v.seq)
+		}
+		if _, ok := seqs[v.seq]; ok {
+			return fmt.Errorf("key %v shares insertion sequence %d", k, v.seq)
+		}
+		seqs[v.seq] = struct{}{}
+		if v.notified && !v.managed {
+			return fmt.Errorf("key %v is notified but not managed", k)
+		}
+		if _, ok := cm.tombstones[k]; ok {
+			return fmt.Errorf("key %v is both present and tombstoned", k)
+		}
+	}
+	if err := cm.checkAliasesLocked(); err != nil {
+		return err
+	}
+	return cm.checkDependentsLocked()
+}
+
+// 检查内部数据结构是否一致, 用于测试和调试, 返回第一个发现的问题
+func (w *Map) CheckInvariants() error {
+	return w.checkInvariants()
+}
+
+// 获取键值对的值并断言为类型 T, 类型不符时返回 ErrorWrongType
+func GetAs[T any](c CacheMap, key interface{}) (T, error) {
+	var zero T
+	item, err := c.Get(key)
+	if err != nil {
+		return zero, err
+	}
+	v, ok := item.Value.(T)
+	if !ok {
+		return zero, errors.New(fmt.Sprintf(ErrorWrongType+": %T", item.Value))
+	}
+	return v, nil
+}
+
+// 遍历 Map 并附带键值对所在的分片序号
+// 当前只有单个 Map 而没有分片, 分片序号恒为 0
+func (w *Map) ForeachWithShard(fn func(shard int, item CacheItem)) {
+	w.foreach(func(item CacheItem) {
+		fn(0, item)
+	})
+}
+
+func (cm *cacheMap) entryLock(key interface{}) (*sync.Mutex, interface{}, error) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	key, k, err := cm.mapKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	item, ok := cm.m[k]
+	if !ok || cm.stateAt(k, item, cm.now()) == EntryExpired {
+		return nil, nil, errors.New(ErrorKeyNotFound)
+	}
+	if item.mu == nil {
+		item = cm.cloneItem(item)
+		item.mu = new(sync.Mutex)
+		cm.m[k] = item
+	}
+	return item.mu, item.Value, nil
+}
+
+// 持有该键值对自身的锁调用 fn, 期间不持有 Map 的锁, 其他键可以被正常访问
+// 只有通过 WithLock 进行的修改之间互斥, SetValue 等操作不会获取该锁
+func (w *Map) WithLock(key interface{}, fn func(value interface{}) error) error {
+	mu, value, err := w.entryLock(key)
+	if err != nil {
+		return err
+	}
+	if value, err = w.decodeValue(value); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return fn(value)
+}
+
+// 按当前大小重建内部 map 以释放大量删除后残留的内存, 调用方需持有写锁
+func (cm *cacheMap) compactLocked() {
+	m := make(map[interface{}]*CacheItem, len(cm.m))
+	for k, v := range cm.m {
+		m[k] = v
+	}
+	cm.m = m
+	cm.peak = len(m)
+}
+
+func (cm *cacheMap) compact() {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	cm.compactLocked()
+}
+
+// 重建内部 map, Go 的 map 不会在删除后缩小, 大量删除后可调用此方法释放内存
+func (w *Map) Compact() {
+	w.compact()
+}
+
+func (cm *cacheMap) merge(key, value interface{}, combine func(existing, incoming interface{}) interface{}, ttl time.Duration) error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return err
+	}
+	item, ok := cm.liveLocked(k, cm.now())
+	if !ok {
+		// value 已在锁外检查
+		encoded, err := cm.encodeValue(value)
+		if err != nil {
+			return err
+		}
+		cm.insertLocked(k, cm.newItem(key, encoded, ttl, nil))
+		return nil
+	}
+	existing, err := cm.decodeValue(item.Value)
+	if err != nil {
+		return err
+	}
+	cm.enterCallback()
+	combined := combine(existing, value)
+	cm.leaveCallback()
+	if err := cm.checkNil(combined); err != nil {
+		return err
+	}
+	if err := cm.checkMergedLocked(key, combined); err != nil {
+		return err
+	}
+	combined, err = cm.encodeValue(combined)
+	if err != nil {
+		return err
+	}
+	item = cm.cloneItem(item)
+	cm.assign(item, combined)
+	cm.m[k] = item
+	return nil
+}
+
+// 键不存在时以 ttl 添加 value, 存在时在写锁内将值替换为 combine(原值, value), 保留原有 TTL 和唤醒函数
+// value 在锁外经过 Validator 校验, 合并后的值在写锁内再次校验
+func (w *Map) Merge(key, value interface{}, combine func(existing, incoming interface{}) interface{}, ttl time.Duration) error {
+	if err := w.checkValue(key, value); err != nil {
+		return err
+	}
+	ttl = w.ttlFor(key, value, ttl)
+	return w.merge(key, value, combine, ttl)
+}
+
+// 暂停定时清理, 期间过期的键值对不会被删除, 也不会调用唤醒函数
+// 暂停期间 Get 的行为不变, 仍会返回已过期但尚未清理的键值对, 可通过 GetDetailed 或 Has 判断是否过期
+func (w *Map) PauseSweeper() {
+	atomic.StoreInt32(&w.paused, 1)
+}
+
+// 恢复定时清理, 暂停期间过期的键值对会在下一轮清理时被删除
+func (w *Map) ResumeSweeper() {
+	atomic.StoreInt32(&w.paused, 0)
 }
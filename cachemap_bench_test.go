@@ -0,0 +1,324 @@
+package cachemap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 运行: go test -run '^$' -bench . -benchmem
+// SyncMap 和 MutexMap 开头的基准为对照组, 用于评估 Map 自身的锁和 CacheItem 复制等开销
+
+const benchKeys = 1 << 16
+
+var benchKeyList = func() []string {
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	return keys
+}()
+
+func newBenchMap(b *testing.B, n int) CacheMap {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	b.Cleanup(m.Stop)
+	for i := 0; i < n; i++ {
+		if err := m.Add(benchKeyList[i%benchKeys]+"/"+strconv.Itoa(i/benchKeys), i, time.Hour, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return m
+}
+
+func BenchmarkGetHit(b *testing.B) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	for i, k := range benchKeyList {
+		m.Add(k, i, time.Hour, nil)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := m.Get(benchKeyList[i%benchKeys]); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkGetMiss(b *testing.B) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			m.Get(benchKeyList[i%benchKeys])
+			i++
+		}
+	})
+}
+
+func BenchmarkAdd(b *testing.B) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k := benchKeyList[i%benchKeys]
+		if err := m.Add(k, i, time.Hour, nil); err != nil {
+			m.Del(k)
+		}
+	}
+}
+
+// 90% 读 10% 写, 分别以 1, 8 和 64 个协程运行
+func BenchmarkMixed(b *testing.B) {
+	for _, procs := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("goroutines=%d", procs), func(b *testing.B) {
+			m := NewCacheMap(Option{SleepTime: time.Hour})
+			defer m.Stop()
+			for i, k := range benchKeyList {
+				m.Add(k, i, time.Hour, nil)
+			}
+			benchMixed(b, procs, func(k string, write bool) {
+				if write {
+					m.Set(k, 0, time.Hour, nil)
+				} else {
+					m.Get(k)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSyncMapMixed(b *testing.B) {
+	for _, procs := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("goroutines=%d", procs), func(b *testing.B) {
+			var m sync.Map
+			for i, k := range benchKeyList {
+				m.Store(k, i)
+			}
+			benchMixed(b, procs, func(k string, write bool) {
+				if write {
+					m.Store(k, 0)
+				} else {
+					m.Load(k)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkMutexMapMixed(b *testing.B) {
+	for _, procs := range []int{1, 8, 64} {
+		b.Run(fmt.Sprintf("goroutines=%d", procs), func(b *testing.B) {
+			var lock sync.RWMutex
+			m := make(map[interface{}]interface{}, benchKeys)
+			for i, k := range benchKeyList {
+				m[k] = i
+			}
+			benchMixed(b, procs, func(k string, write bool) {
+				if write {
+					lock.Lock()
+					m[k] = 0
+					lock.Unlock()
+				} else {
+					lock.RLock()
+					_ = m[k]
+					lock.RUnlock()
+				}
+			})
+		})
+	}
+}
+
+// 以 procs 个协程并发调用 op, 每 10 次操作中有 1 次写
+func benchMixed(b *testing.B, procs int, op func(k string, write bool)) {
+	b.ReportAllocs()
+	b.SetParallelism(procs)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			op(benchKeyList[i%benchKeys], i%10 == 0)
+			i++
+		}
+	})
+}
+
+func BenchmarkForeach100k(b *testing.B) {
+	m := newBenchMap(b, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		count := 0
+		m.Foreach(func(item CacheItem) {
+			count++
+		})
+	}
+}
+
+func BenchmarkSyncMapRange100k(b *testing.B) {
+	var m sync.Map
+	for i := 0; i < 100000; i++ {
+		m.Store(i, i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		count := 0
+		m.Range(func(k, v interface{}) bool {
+			count++
+			return true
+		})
+	}
+}
+
+// 1M 个键值对中有 1% 已过期时一轮清理的耗时, 每轮清理前补回被删除的键值对
+func BenchmarkSweep1M(b *testing.B) {
+	benchSweep1M(b, Option{SleepTime: time.Hour}, 100)
+}
+
+// 设置 BatchCallback 且每轮 1k 个键值对过期时的清理, 稳定后复用缓冲区, 每轮不应有内存分配
+func BenchmarkSweep1MBatchCallback(b *testing.B) {
+	expired := 0
+	benchSweep1M(b, Option{
+		SleepTime:     time.Hour,
+		BatchCallback: func(items []CacheItem) { expired += len(items) },
+	}, 1000)
+}
+
+// 1M 个键值对中每 step 个有一个已过期
+func benchSweep1M(b *testing.B, option Option, step int) {
+	if testing.Short() {
+		b.Skip("skipping 1M entry sweep in short mode")
+	}
+	const n = 1000000
+	m := NewCacheMap(option)
+	defer m.Stop()
+	for i := 0; i < n; i++ {
+		m.Add(i, i, time.Hour, nil)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for j := 0; j < n; j += step {
+			m.Del(j)
+			m.Add(j, j, time.Nanosecond, nil)
+		}
+		time.Sleep(time.Millisecond)
+		b.StartTimer()
+		m.sweep()
+	}
+}
+
+// 写时复制的开销, 每次修改都会分配新的 CacheItem
+func BenchmarkSetValue(b *testing.B) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	m.Add("k", 0, time.Hour, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.SetValue("k", i)
+	}
+}
+
+func BenchmarkSetTTL(b *testing.B) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	m.Add("k", 0, time.Hour, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.SetTTL("k", time.Hour, true)
+	}
+}
+
+func BenchmarkSetValueWithReaders(b *testing.B) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	m.Add("k", 0, time.Hour, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if i%10 == 0 {
+				m.SetValue("k", i)
+			} else {
+				m.GetRef("k")
+			}
+			i++
+		}
+	})
+}
+
+type benchRecord struct {
+	Name  string
+	Score int64
+}
+
+// benchRecord 的紧凑编码, 名称之后是 8 字节的分数
+type benchRecordCodec struct{}
+
+func (benchRecordCodec) Marshal(value interface{}) ([]byte, error) {
+	r := value.(benchRecord)
+	data := make([]byte, len(r.Name)+8)
+	copy(data, r.Name)
+	binary.LittleEndian.PutUint64(data[len(r.Name):], uint64(r.Score))
+	return data, nil
+}
+
+func (benchRecordCodec) Unmarshal(data []byte) (interface{}, error) {
+	n := len(data) - 8
+	return benchRecord{Name: string(data[:n]), Score: int64(binary.LittleEndian.Uint64(data[n:]))}, nil
+}
+
+// 1M 个小结构体时一次完整 GC 的停顿时间, 对比是否设置 SerializeValues
+func BenchmarkGCPause1M(b *testing.B) {
+	if testing.Short() {
+		b.Skip("skipping 1M entry GC benchmark in short mode")
+	}
+	for _, serialize := range []bool{false, true} {
+		b.Run(fmt.Sprintf("serialize=%v", serialize), func(b *testing.B) {
+			option := Option{SleepTime: time.Hour}
+			if serialize {
+				option.SerializeValues = true
+				option.Codec = benchRecordCodec{}
+			}
+			m, err := New(option)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer m.Stop()
+			for i := 0; i < 1000000; i++ {
+				if err := m.Add(i, benchRecord{Name: strconv.Itoa(i), Score: int64(i)}, 0, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+			runtime.GC()
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+			}
+			b.StopTimer()
+			runtime.ReadMemStats(&after)
+			b.ReportMetric(float64(after.PauseTotalNs-before.PauseTotalNs)/float64(after.NumGC-before.NumGC), "pause-ns/GC")
+			b.ReportMetric(float64(after.HeapObjects), "heap-objects")
+			runtime.KeepAlive(m)
+		})
+	}
+}
@@ -0,0 +1,612 @@
+package cachemap
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStopAndWaitInCallback(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Millisecond})
+	done := make(chan struct{})
+	err := m.Add("k", 1, time.Millisecond, func(item CacheItem) {
+		m.StopAndWait()
+		close(done)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("StopAndWait in callback did not return")
+	}
+}
+
+// 在持有锁时调用的用户函数内调用 StopAndWait 不会死锁, 即使清理协程正在等待获取写锁
+func TestStopAndWaitUnderLock(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(m CacheMap, fn func())
+	}{
+		{"Foreach", func(m CacheMap, fn func()) {
+			m.Foreach(func(CacheItem) { fn() })
+		}},
+		{"GroupBy", func(m CacheMap, fn func()) {
+			m.GroupBy(func(CacheItem) string { fn(); return "" })
+		}},
+		{"GetGrouped", func(m CacheMap, fn func()) {
+			m.GetGrouped([]interface{}{"k"}, func(CacheItem) string { fn(); return "" })
+		}},
+		{"Transaction", func(m CacheMap, fn func()) {
+			m.Transaction(func(tx Txn) error { fn(); return nil })
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewCacheMap(Option{SleepTime: time.Millisecond})
+			if err := m.Add("k", 1, 0, nil); err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan struct{})
+			go func() {
+				tt.run(m, func() {
+					// 等待清理协程阻塞在获取写锁上
+					time.Sleep(10 * time.Millisecond)
+					m.StopAndWait()
+				})
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("StopAndWait deadlocked")
+			}
+			m.StopAndWait()
+		})
+	}
+}
+
+func TestCallFuncSurvivesSetValue(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	var calls []interface{}
+	if err := m.Add("k", 0, time.Hour, func(item CacheItem) {
+		calls = append(calls, item.Value)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 5; i++ {
+		if err := m.SetValue("k", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.SetTTL("k", time.Nanosecond, false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	m.sweep()
+	m.sweep()
+	if len(calls) != 1 || calls[0] != 5 {
+		t.Fatalf("callFunc calls: got %v, want [5]", calls)
+	}
+}
+
+// 在高频增删和过期下, 复用的 CacheItem 不能被回调或读取方看到其他键值对的内容
+func TestPoolItemsChurn(t *testing.T) {
+	m, err := New(Option{SleepTime: time.Millisecond, PoolItems: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	var wg sync.WaitGroup
+	errs := make(chan string, 16)
+	report := func(format string, args ...interface{}) {
+		select {
+		case errs <- fmt.Sprintf(format, args...):
+		default:
+		}
+	}
+	check := func(item CacheItem) {
+		if item.Key != item.Value {
+			report("item %v carries value %v", item.Key, item.Value)
+		}
+	}
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				k := g*100 + i%50
+				m.Set(k, k, time.Duration(i%3)*time.Millisecond, check)
+				if item, err := m.Get(k); err == nil {
+					check(item)
+				}
+				if i%7 == 0 {
+					m.Del(k)
+				}
+			}
+		}(g)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			m.Foreach(check)
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for e := range errs {
+		t.Error(e)
+	}
+}
+
+// 修改操作替换 Map 中的指针而不修改已有的 CacheItem, 通过 GetRef 取得的指针可以与写入并发读取
+func TestCopyOnWriteRace(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	if err := m.Add("k", 0, time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				item, err := m.GetRef("k")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_ = item.Value
+				_ = item.TTL
+				_ = item.UpdateTime
+			}
+		}()
+	}
+	for i := 0; i < 1000; i++ {
+		if err := m.SetValue("k", i); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SetTTL("k", time.Hour, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SetCallFunc("k", func(CacheItem) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	ref, _ := m.GetRef("k")
+	if err := m.SetValue("k", -1); err != nil {
+		t.Fatal(err)
+	}
+	if ref.Value != 999 {
+		t.Errorf("SetValue modified a previously returned item: got %v, want 999", ref.Value)
+	}
+}
+
+// Map 不再被引用时由 finalizer 停止清理协程
+func TestFinalizerStopsSweeper(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	done := m.cacheMap.done
+	m = nil
+	waitGC(t, done)
+}
+
+// 显式 Stop 后 finalizer 已被清除, 之后的 GC 不会再次停止
+func TestFinalizerAfterStop(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	cm := m.cacheMap
+	m.Stop()
+	waitGC(t, cm.done)
+	m.Stop()
+	m = nil
+	for i := 0; i < 3; i++ {
+		runtime.GC()
+	}
+	if !cm.stopped() {
+		t.Fatal("map not stopped")
+	}
+}
+
+func waitGC(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case <-done:
+			return
+		case <-deadline:
+			t.Fatal("sweeper goroutine did not exit")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// 清理过程中 Stop 立即返回, StopAndWait 只等待正在执行的回调, 不等待整轮清理
+func TestStopDuringSlowSweep(t *testing.T) {
+	const (
+		n     = 100
+		delay = 20 * time.Millisecond
+	)
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	entered := make(chan struct{}, n)
+	slow := func(CacheItem) {
+		entered <- struct{}{}
+		time.Sleep(delay)
+	}
+	for i := 0; i < n; i++ {
+		if err := m.Add(i, i, time.Nanosecond, slow); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond)
+	go m.sweep()
+	<-entered
+	start := time.Now()
+	m.Stop()
+	if d := time.Since(start); d > delay/2 {
+		t.Errorf("Stop took %v during a sweep", d)
+	}
+	m.StopAndWait()
+	if d := time.Since(start); d > 5*delay {
+		t.Errorf("StopAndWait took %v, want about one callback (%v)", d, delay)
+	}
+	if c := len(entered); c > 2 {
+		t.Errorf("%d more callbacks ran after Stop", c)
+	}
+}
+
+// 已过期但尚未被清理的键视为不存在, 再次 Add 成功并调用原有的唤醒函数
+func TestAddOverExpired(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	called := 0
+	if err := m.Add("k", 1, time.Millisecond, func(CacheItem) { called++ }); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := m.Add("k", 2, time.Hour, nil); err != nil {
+		t.Fatalf("Add over expired entry: %v", err)
+	}
+	if called != 1 {
+		t.Errorf("callFunc of expired entry: called %d times, want 1", called)
+	}
+	if item, err := m.Get("k"); err != nil || item.Value != 2 {
+		t.Errorf("Get: got %v, %v, want 2", item.Value, err)
+	}
+}
+
+func TestDelPrefix(t *testing.T) {
+	var batch []CacheItem
+	m := NewCacheMap(Option{
+		SleepTime:         time.Hour,
+		TombstoneDuration: time.Hour,
+		BatchCallback:     func(items []CacheItem) { batch = append(batch, items...) },
+	})
+	defer m.Stop()
+	called := 0
+	count := func(CacheItem) { called++ }
+	for _, k := range []string{"a:1", "a:2", "b:1"} {
+		if err := m.Add(k, k, time.Hour, count); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := m.DelPrefix("a:"); n != 2 {
+		t.Errorf("DelPrefix: got %d, want 2", n)
+	}
+	if called != 0 || len(batch) != 0 {
+		t.Errorf("DelPrefix ran callbacks: callFunc %d, batch %d", called, len(batch))
+	}
+	if !m.WasRecentlyDeleted("a:1") || m.WasRecentlyDeleted("b:1") {
+		t.Error("DelPrefix: tombstones not recorded for deleted keys only")
+	}
+	if err := m.Add("a:3", "a:3", time.Hour, count); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.DelPrefixNotify("a:"); n != 1 {
+		t.Errorf("DelPrefixNotify: got %d, want 1", n)
+	}
+	if called != 1 || len(batch) != 1 || batch[0].Key != "a:3" {
+		t.Errorf("DelPrefixNotify callbacks: callFunc %d, batch %v", called, batch)
+	}
+	if !m.WasRecentlyDeleted("a:3") {
+		t.Error("DelPrefixNotify: tombstone not recorded")
+	}
+	if m.Len() != 1 {
+		t.Errorf("Len: got %d, want 1", m.Len())
+	}
+}
+
+// Merge 合并出的值同样经过 Validator 校验, 校验失败时保留原值
+func TestValidatorMerge(t *testing.T) {
+	m, err := New(Option{
+		SleepTime: time.Hour,
+		Validator: func(key, value interface{}) error {
+			if n, ok := value.(int); !ok || n > 10 {
+				return fmt.Errorf("bad value %v", value)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	sum := func(existing, incoming interface{}) interface{} {
+		return existing.(int) + incoming.(int)
+	}
+	if err := m.Merge("k", 6, sum, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Merge("k", 6, sum, 0); err == nil {
+		t.Fatal("Merge: combined value 12 passed the validator")
+	}
+	if item, err := m.Get("k"); err != nil || item.Value != 6 {
+		t.Errorf("Get: got %v, %v, want 6", item.Value, err)
+	}
+}
+
+func TestForbidNilValues(t *testing.T) {
+	m, err := New(Option{SleepTime: time.Hour, ForbidNilValues: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	toNil := func(existing, incoming interface{}) interface{} { return nil }
+	if err := m.Add("k", 1, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		write func() error
+	}{
+		{"Add", func() error { return m.Add("a", nil, 0, nil) }},
+		{"Set", func() error { _, _, err := m.Set("k", nil, 0, nil); return err }},
+		{"SetValue", func() error { return m.SetValue("k", nil) }},
+		{"Merge", func() error { return m.Merge("k", 2, toNil, 0) }},
+		{"HSet", func() error { return m.HSet("h", "f", nil) }},
+		{"PushToList", func() error { return m.PushToList("l", nil, 0, 0) }},
+	}
+	for _, tt := range tests {
+		if err := tt.write(); err == nil || err.Error() != ErrorNilValue {
+			t.Errorf("%s: got %v, want %s", tt.name, err, ErrorNilValue)
+		}
+	}
+	if item, err := m.Get("k"); err != nil || item.Value != 1 {
+		t.Errorf("Get: got %v, %v, want 1", item.Value, err)
+	}
+	if m.Len() != 1 {
+		t.Errorf("Len: got %d, want 1", m.Len())
+	}
+	var typed *int
+	if err := m.Add("typed", typed, 0, nil); err != nil {
+		t.Errorf("Add typed nil: %v", err)
+	}
+}
+
+// 未设置 ForbidNilValues 时 nil 值原样返回, 并在 Has 和 Len 中视为存在
+func TestNilValueRoundTrip(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	toNil := func(existing, incoming interface{}) interface{} { return nil }
+	if err := m.Add("a", nil, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add("m", 1, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Merge("m", 2, toNil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.HSet("h", "f", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PushToList("l", nil, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "m"} {
+		if item, err := m.Get(k); err != nil || item.Value != nil {
+			t.Errorf("Get(%s): got %v, %v, want nil", k, item.Value, err)
+		}
+	}
+	if v, err := m.HGet("h", "f"); err != nil || v != nil {
+		t.Errorf("HGet: got %v, %v, want nil", v, err)
+	}
+	if l := m.GetList("l"); len(l) != 1 || l[0].Value != nil {
+		t.Errorf("GetList: got %v, want one nil element", l)
+	}
+	for _, k := range []string{"a", "m", "h", "l"} {
+		if !m.Has(k) {
+			t.Errorf("Has(%s): got false, want true", k)
+		}
+	}
+	if m.Len() != 4 {
+		t.Errorf("Len: got %d, want 4", m.Len())
+	}
+}
+
+// 清理时按 GlobalExpireMode 决定是否对带有和不带唤醒函数的键值对调用 OnExpire
+func TestGlobalExpireMode(t *testing.T) {
+	tests := []struct {
+		name string
+		mode GlobalExpireMode
+		want []string
+	}{
+		{"Always", GlobalExpireAlways, []string{"with", "without"}},
+		{"OnlyWithoutItemFunc", GlobalExpireOnlyWithoutItemFunc, []string{"without"}},
+		{"Supplement", GlobalExpireSupplement, []string{"with"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var global []string
+			itemCalls := 0
+			m, err := New(Option{
+				SleepTime:        time.Hour,
+				GlobalExpireMode: tt.mode,
+				OnExpire:         func(item CacheItem) { global = append(global, item.Key.(string)) },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Stop()
+			if err := m.Add("with", 1, time.Nanosecond, func(CacheItem) { itemCalls++ }); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Add("without", 2, time.Nanosecond, nil); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(time.Millisecond)
+			m.sweep()
+			sort.Strings(global)
+			if fmt.Sprint(global) != fmt.Sprint(tt.want) {
+				t.Errorf("OnExpire: got %v, want %v", global, tt.want)
+			}
+			if itemCalls != 1 {
+				t.Errorf("callFunc: called %d times, want 1", itemCalls)
+			}
+			if m.Len() != 0 {
+				t.Errorf("Len: got %d, want 0", m.Len())
+			}
+		})
+	}
+}
+
+// 刷新或修改已有键值对时保留 CreateTime
+func TestCreateTimeSurvivesRefresh(t *testing.T) {
+	sum := func(existing, incoming interface{}) interface{} { return existing.(int) + incoming.(int) }
+	tests := []struct {
+		name    string
+		option  Option
+		create  func(m CacheMap) error
+		refresh func(m CacheMap) error
+	}{
+		{"SetTTL reset", Option{}, nil, func(m CacheMap) error { return m.SetTTL("k", time.Hour, true) }},
+		{"SetTTL", Option{}, nil, func(m CacheMap) error { return m.SetTTL("k", time.Hour, false) }},
+		{"Set", Option{}, nil, func(m CacheMap) error { _, _, err := m.Set("k", 2, time.Hour, nil); return err }},
+		{"SetValue", Option{}, nil, func(m CacheMap) error { return m.SetValue("k", 2) }},
+		{"Merge", Option{}, nil, func(m CacheMap) error { return m.Merge("k", 2, sum, time.Hour) }},
+		{"AddRefreshesExisting", Option{AddRefreshesExisting: true, AddRefreshesTTL: true}, nil,
+			func(m CacheMap) error { return m.Add("k", 2, time.Hour, nil) }},
+		{"AddOverwrites", Option{AddOverwrites: true}, nil, func(m CacheMap) error { return m.Add("k", 2, time.Hour, nil) }},
+		{"Transaction Set", Option{}, nil, func(m CacheMap) error {
+			return m.Transaction(func(tx Txn) error { _, _, err := tx.Set("k", 2, time.Hour); return err })
+		}},
+		{"HSet", Option{}, func(m CacheMap) error { return m.HSet("k", "a", 1) },
+			func(m CacheMap) error { return m.HSet("k", "b", 2) }},
+		{"PushToList", Option{}, func(m CacheMap) error { return m.PushToList("k", 1, 0, 0) },
+			func(m CacheMap) error { return m.PushToList("k", 2, 0, 0) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, advance := newManualClock(t, tt.option)
+			created := m.now()
+			create := tt.create
+			if create == nil {
+				create = func(m CacheMap) error { return m.Add("k", 1, time.Hour, nil) }
+			}
+			if err := create(m); err != nil {
+				t.Fatal(err)
+			}
+			advance(time.Minute)
+			if err := tt.refresh(m); err != nil {
+				t.Fatal(err)
+			}
+			item, err := m.Get("k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !item.CreateTime.Equal(created) {
+				t.Errorf("CreateTime: got %v, want %v", item.CreateTime, created)
+			}
+		})
+	}
+}
+
+// Shutdown 之后写入返回 ErrorClosed, 只删除键值对的操作仍然生效
+func TestShutdownStopsWrites(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	for _, k := range []string{"a", "b", "c:1", "c:2"} {
+		if err := m.Add(k, 1, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	writes := []struct {
+		name  string
+		write func() error
+	}{
+		{"Add", func() error { return m.Add("x", 1, 0, nil) }},
+		{"Set", func() error { _, _, err := m.Set("a", 2, 0, nil); return err }},
+		{"SetValue", func() error { return m.SetValue("a", 2) }},
+		{"SetTTL", func() error { return m.SetTTL("a", time.Hour, true) }},
+		{"HSet", func() error { return m.HSet("h", "f", 1) }},
+		{"PushToList", func() error { return m.PushToList("l", 1, 0, 0) }},
+		{"Transaction Set", func() error {
+			return m.Transaction(func(tx Txn) error { _, _, err := tx.Set("a", 2, 0); return err })
+		}},
+	}
+	for _, w := range writes {
+		if err := w.write(); err == nil || err.Error() != ErrorClosed {
+			t.Errorf("%s: got %v, want %s", w.name, err, ErrorClosed)
+		}
+	}
+	if v := m.GetOrAddPointer("p", 0, func() interface{} { return new(int) }); v != nil {
+		t.Errorf("GetOrAddPointer: got %v, want nil", v)
+	}
+	snap := m.SnapshotAndReset(func(interface{}) interface{} { return 0 })
+	if snap["a"] != 1 {
+		t.Errorf("SnapshotAndReset: got %v, want a=1", snap)
+	}
+	if item, err := m.Get("a"); err != nil || item.Value != 1 {
+		t.Errorf("SnapshotAndReset reset after Shutdown: got %v, %v", item.Value, err)
+	}
+	if err := m.Del("a"); err != nil {
+		t.Errorf("Del: %v", err)
+	}
+	if n := m.DelPrefix("c:"); n != 2 {
+		t.Errorf("DelPrefix: got %d, want 2", n)
+	}
+	m.Clear()
+	if m.Len() != 0 {
+		t.Errorf("Len: got %d, want 0", m.Len())
+	}
+}
+
+// DeterministicIteration 按键的字符串形式排序, 字符串相同时按类型名排序
+func TestDeterministicIteration(t *testing.T) {
+	m, err := New(Option{SleepTime: time.Hour, DeterministicIteration: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	for _, k := range []interface{}{"b", 1, "1", "a", int8(1)} {
+		if err := m.Add(k, nil, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	m.Foreach(func(item CacheItem) {
+		got = append(got, fmt.Sprintf("%T(%v)", item.Key, item.Key))
+	})
+	want := "[int(1) int8(1) string(1) string(a) string(b)]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("Foreach order: got %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,189 @@
+// Package cachemaptest 提供检查 cachemap.Cache 实现的工具
+package cachemaptest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yaotthaha/cachemap"
+)
+
+// 对 newCache 创建的实现逐项检查 cachemap.Cache 文档中的读写和遍历一致性保证
+// 每个子测试创建一个新实例, 测试结束时调用其 Stop, 写入的键值对均不过期
+func RunConformance(t *testing.T, newCache func(t *testing.T) cachemap.Cache) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, c cachemap.Cache)
+	}{
+		{"ReadYourWrites", testReadYourWrites},
+		{"CrossGoroutineVisibility", testCrossGoroutineVisibility},
+		{"Del", testDel},
+		{"Clear", testClear},
+		{"ForeachSnapshot", testForeachSnapshot},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCache(t)
+			defer c.Stop()
+			tt.run(t, c)
+		})
+	}
+}
+
+func checkValue(t *testing.T, c cachemap.Cache, key, want interface{}) {
+	t.Helper()
+	item, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%v): %v", key, err)
+	}
+	if item.Value != want {
+		t.Fatalf("Get(%v): got %v, want %v", key, item.Value, want)
+	}
+	if !c.Has(key) {
+		t.Fatalf("Has(%v): got false after Get succeeded", key)
+	}
+}
+
+func testReadYourWrites(t *testing.T, c cachemap.Cache) {
+	if err := c.Add("k", 1, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, c, "k", 1)
+	if err := c.SetValue("k", 2); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, c, "k", 2)
+	if err := c.SetTTL("k", time.Hour, true); err != nil {
+		t.Fatal(err)
+	}
+	checkValue(t, c, "k", 2)
+	if n := c.Len(); n != 1 {
+		t.Fatalf("Len: got %d, want 1", n)
+	}
+}
+
+// 一个协程写入返回后通知另一个协程, 后者必须看到写入
+func testCrossGoroutineVisibility(t *testing.T, c cachemap.Cache) {
+	const n = 200
+	written := make(chan int)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(written)
+		for i := 0; i < n; i++ {
+			if err := c.Add(i, i, 0, nil); err != nil {
+				errs <- err
+				return
+			}
+			written <- i
+		}
+	}()
+	for i := range written {
+		item, err := c.Get(i)
+		if err != nil || item.Value != i {
+			t.Fatalf("Get(%d) after Add returned: got %v, %v", i, item.Value, err)
+		}
+	}
+	select {
+	case err := <-errs:
+		t.Fatal(err)
+	default:
+	}
+	if l := c.Len(); l != n {
+		t.Fatalf("Len: got %d, want %d", l, n)
+	}
+}
+
+func testDel(t *testing.T, c cachemap.Cache) {
+	const n = 100
+	for i := 0; i < n; i++ {
+		if err := c.Add(i, i, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deleted := make(chan int)
+	go func() {
+		defer close(deleted)
+		for i := 0; i < n; i += 2 {
+			c.Del(i)
+			deleted <- i
+		}
+	}()
+	for i := range deleted {
+		if _, err := c.Get(i); err == nil || err.Error() != cachemap.ErrorKeyNotFound {
+			t.Fatalf("Get(%d) after Del returned: got %v, want %s", i, err, cachemap.ErrorKeyNotFound)
+		}
+		if c.Has(i) {
+			t.Fatalf("Has(%d) after Del returned: got true", i)
+		}
+	}
+	if l := c.Len(); l != n/2 {
+		t.Fatalf("Len: got %d, want %d", l, n/2)
+	}
+	if err := c.Del(0); err == nil || err.Error() != cachemap.ErrorKeyNotFound {
+		t.Fatalf("Del of a deleted key: got %v, want %s", err, cachemap.ErrorKeyNotFound)
+	}
+}
+
+func testClear(t *testing.T, c cachemap.Cache) {
+	for i := 0; i < 10; i++ {
+		if err := c.Add(i, i, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Clear()
+	if l := c.Len(); l != 0 {
+		t.Fatalf("Len after Clear: got %d, want 0", l)
+	}
+	if c.Has(0) {
+		t.Fatal("Has after Clear: got true")
+	}
+	if err := c.Add(0, 1, 0, nil); err != nil {
+		t.Fatalf("Add after Clear: %v", err)
+	}
+}
+
+// 遍历期间其他协程并发写入, 遍历仍只看到开始时的键值对, 且各访问一次
+func testForeachSnapshot(t *testing.T, c cachemap.Cache) {
+	const n = 1000
+	for i := 0; i < n; i++ {
+		if err := c.Add(i, i, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := make(map[interface{}]int, n)
+	var wg sync.WaitGroup
+	started := make(chan struct{})
+	first := true
+	c.Foreach(func(item cachemap.CacheItem) {
+		if first {
+			first = false
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				close(started)
+				for i := 0; i < n; i++ {
+					c.Add(n+i, i, 0, nil)
+					c.SetValue(i, -1)
+				}
+			}()
+			<-started
+		}
+		seen[item.Key]++
+		if item.Value != item.Key {
+			t.Errorf("Foreach saw %v=%v, a write made during the walk", item.Key, item.Value)
+		}
+	})
+	wg.Wait()
+	if len(seen) != n {
+		t.Fatalf("Foreach visited %d keys, want %d", len(seen), n)
+	}
+	for k, count := range seen {
+		if count != 1 {
+			t.Fatalf("Foreach visited %v %d times", k, count)
+		}
+	}
+	if l := c.Len(); l != 2*n {
+		t.Fatalf("Len after the concurrent writes: got %d, want %d", l, 2*n)
+	}
+}
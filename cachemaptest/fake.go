@@ -0,0 +1,293 @@
+package cachemaptest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yaotthaha/cachemap"
+)
+
+// 一次对 Fake 的调用, Err 为调用返回的错误, 没有返回错误的方法为 nil
+type Op struct {
+	Name  string
+	Key   interface{}
+	Value interface{}
+	TTL   time.Duration
+	Err   error
+}
+
+type fakeEntry struct {
+	item     cachemap.CacheItem
+	callFunc cachemap.CallFuncType
+}
+
+// cachemap.Cache 的内存实现, 使用手动推进的时钟, 只在 AdvanceTime 时按到期时间清理过期键值对
+// 所有调用都会被记录, 可通过 Ops 取得, 适合在单元测试中替代真实的 Map
+type Fake struct {
+	lock    sync.RWMutex
+	now     time.Time
+	m       map[interface{}]*fakeEntry
+	ops     []Op
+	stopped bool
+}
+
+var _ cachemap.Cache = (*Fake)(nil)
+
+// 创建一个时钟从 start 开始的 Fake
+func NewFake(start time.Time) *Fake {
+	return &Fake{
+		now: start,
+		m:   make(map[interface{}]*fakeEntry),
+	}
+}
+
+func (f *Fake) record(op Op) {
+	f.ops = append(f.ops, op)
+}
+
+func checkKey(key interface{}) error {
+	if tp, ok := cachemap.CheckKeyType(key); !ok {
+		return errors.New(fmt.Sprintf(cachemap.ErrorInvalidKeyType+": %s", tp))
+	}
+	return nil
+}
+
+// 查找未过期的键值对, 调用方需持有锁
+func (f *Fake) live(key interface{}) (*fakeEntry, bool) {
+	e, ok := f.m[key]
+	if !ok || e.item.StateAt(f.now) == cachemap.EntryExpired {
+		return nil, false
+	}
+	return e, true
+}
+
+// 添加一个键值对, 键已存在且未过期时返回 ErrorKeyExist
+func (f *Fake) Add(key, value interface{}, ttl time.Duration, callFunc cachemap.CallFuncType) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	err := f.add(key, value, ttl, callFunc)
+	f.record(Op{Name: "Add", Key: key, Value: value, TTL: ttl, Err: err})
+	return err
+}
+
+func (f *Fake) add(key, value interface{}, ttl time.Duration, callFunc cachemap.CallFuncType) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if _, ok := f.live(key); ok {
+		return errors.New(cachemap.ErrorKeyExist)
+	}
+	f.m[key] = &fakeEntry{
+		item: cachemap.CacheItem{
+			Key:        key,
+			Value:      value,
+			TTL:        ttl,
+			UpdateTime: f.now,
+			CreateTime: f.now,
+		},
+		callFunc: callFunc,
+	}
+	return nil
+}
+
+// 删除一个键值对, 不调用唤醒函数
+func (f *Fake) Del(key interface{}) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	err := checkKey(key)
+	if err == nil {
+		// 与 Map 一样, 已过期但尚未清理的键值对同样被删除, 但返回 ErrorKeyNotFound
+		if _, ok := f.live(key); !ok {
+			err = errors.New(cachemap.ErrorKeyNotFound)
+		}
+		delete(f.m, key)
+	}
+	f.record(Op{Name: "Del", Key: key, Err: err})
+	return err
+}
+
+// 获取一个键值对, 与 Map 一样, 已过期但尚未清理的键值对仍会返回
+func (f *Fake) Get(key interface{}) (cachemap.CacheItem, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var item cachemap.CacheItem
+	err := checkKey(key)
+	if err == nil {
+		if e, ok := f.m[key]; ok {
+			item = e.item
+		} else {
+			err = errors.New(cachemap.ErrorKeyNotFound)
+		}
+	}
+	f.record(Op{Name: "Get", Key: key, Err: err})
+	return item, err
+}
+
+// 判断键是否存在且未过期
+func (f *Fake) Has(key interface{}) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.record(Op{Name: "Has", Key: key})
+	if checkKey(key) != nil {
+		return false
+	}
+	_, ok := f.live(key)
+	return ok
+}
+
+// 获取键值对数量, 包含已过期但尚未清理的键值对
+func (f *Fake) Len() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.record(Op{Name: "Len"})
+	return len(f.m)
+}
+
+// 设置值, 保留 TTL, UpdateTime 和唤醒函数
+func (f *Fake) SetValue(key, value interface{}) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	err := checkKey(key)
+	if err == nil {
+		if e, ok := f.m[key]; ok {
+			e.item.Value = value
+		} else {
+			err = errors.New(cachemap.ErrorKeyNotFound)
+		}
+	}
+	f.record(Op{Name: "SetValue", Key: key, Value: value, Err: err})
+	return err
+}
+
+// 设置 TTL, resetUpdateTime 为 true 时以当前时钟重新计时
+func (f *Fake) SetTTL(key interface{}, ttl time.Duration, resetUpdateTime bool) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	err := checkKey(key)
+	if err == nil {
+		if e, ok := f.m[key]; ok {
+			e.item.TTL = ttl
+			if resetUpdateTime {
+				e.item.UpdateTime = f.now
+			}
+		} else {
+			err = errors.New(cachemap.ErrorKeyNotFound)
+		}
+	}
+	f.record(Op{Name: "SetTTL", Key: key, TTL: ttl, Err: err})
+	return err
+}
+
+// 替换唤醒函数
+func (f *Fake) SetCallFunc(key interface{}, callFunc cachemap.CallFuncType) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	err := checkKey(key)
+	if err == nil {
+		if e, ok := f.m[key]; ok {
+			e.callFunc = callFunc
+		} else {
+			err = errors.New(cachemap.ErrorKeyNotFound)
+		}
+	}
+	f.record(Op{Name: "SetCallFunc", Key: key, Err: err})
+	return err
+}
+
+// 在锁内遍历所有键值对, 与 Map 一样遍历期间的写入会等待遍历结束, fn 内不能调用 Fake 的方法
+func (f *Fake) Foreach(fn cachemap.CallFuncType) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.record(Op{Name: "Foreach"})
+	for _, e := range f.m {
+		fn(e.item)
+	}
+}
+
+// 删除所有键值对, 不调用唤醒函数
+func (f *Fake) Clear() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.record(Op{Name: "Clear"})
+	f.m = make(map[interface{}]*fakeEntry)
+}
+
+// 停止清理, 之后 AdvanceTime 只推进时钟而不再清理过期键值对
+func (f *Fake) Stop() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.record(Op{Name: "Stop"})
+	f.stopped = true
+}
+
+// 当前时钟
+func (f *Fake) Now() time.Time {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.now
+}
+
+// 将时钟推进 d, 未 Stop 时清理已过期的键值对, 并在锁外按到期时间顺序调用其唤醒函数
+func (f *Fake) AdvanceTime(d time.Duration) {
+	f.lock.Lock()
+	f.now = f.now.Add(d)
+	var expired []*fakeEntry
+	if !f.stopped {
+		for k, e := range f.m {
+			if e.item.StateAt(f.now) == cachemap.EntryExpired {
+				expired = append(expired, e)
+				delete(f.m, k)
+			}
+		}
+	}
+	f.lock.Unlock()
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].item.UpdateTime.Add(expired[i].item.TTL).Before(expired[j].item.UpdateTime.Add(expired[j].item.TTL))
+	})
+	for _, e := range expired {
+		if e.callFunc != nil {
+			e.callFunc(e.item)
+		}
+	}
+}
+
+// 返回目前为止记录的所有调用
+func (f *Fake) Ops() []Op {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return append([]Op(nil), f.ops...)
+}
+
+// 清空已记录的调用
+func (f *Fake) ResetOps() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.ops = nil
+}
+
+// 断言 c 中存在未过期的 key 且值为 want
+func AssertContains(t testing.TB, c cachemap.Cache, key, want interface{}) {
+	t.Helper()
+	if !c.Has(key) {
+		t.Fatalf("%v: not present", key)
+	}
+	item, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("%v: %v", key, err)
+	}
+	if item.Value != want {
+		t.Fatalf("%v: got %v, want %v", key, item.Value, want)
+	}
+}
+
+// 断言 c 中不存在未过期的 key
+func AssertMissing(t testing.TB, c cachemap.Cache, key interface{}) {
+	t.Helper()
+	if c.Has(key) {
+		t.Fatalf("%v: present, want missing", key)
+	}
+}
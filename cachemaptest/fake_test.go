@@ -0,0 +1,97 @@
+package cachemaptest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yaotthaha/cachemap"
+)
+
+var start = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeConformance(t *testing.T) {
+	RunConformance(t, func(t *testing.T) cachemap.Cache {
+		return NewFake(start)
+	})
+}
+
+func TestFakeAdvanceTime(t *testing.T) {
+	f := NewFake(start)
+	var expired []interface{}
+	record := func(item cachemap.CacheItem) { expired = append(expired, item.Value) }
+	if err := f.Add("a", 1, time.Minute, record); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add("b", 2, 2*time.Minute, record); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add("c", 3, 0, record); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetValue("a", 10); err != nil {
+		t.Fatal(err)
+	}
+	// 恰好在到期时间时仍未过期
+	f.AdvanceTime(time.Minute)
+	AssertContains(t, f, "a", 10)
+	f.AdvanceTime(2 * time.Minute)
+	AssertMissing(t, f, "a")
+	AssertMissing(t, f, "b")
+	AssertContains(t, f, "c", 3)
+	if len(expired) != 2 || expired[0] != 10 || expired[1] != 2 {
+		t.Errorf("callbacks: got %v, want [10 2]", expired)
+	}
+	if !f.Now().Equal(start.Add(3 * time.Minute)) {
+		t.Errorf("Now: got %v, want %v", f.Now(), start.Add(3*time.Minute))
+	}
+}
+
+// Stop 后 AdvanceTime 不再清理, 过期的键值对按 Map 的规则视为不存在但仍计入 Len
+func TestFakeStop(t *testing.T) {
+	f := NewFake(start)
+	called := false
+	if err := f.Add("k", 1, time.Minute, func(cachemap.CacheItem) { called = true }); err != nil {
+		t.Fatal(err)
+	}
+	f.Stop()
+	f.AdvanceTime(time.Hour)
+	AssertMissing(t, f, "k")
+	if called || f.Len() != 1 {
+		t.Errorf("after Stop: callback %v, Len %d, want false and 1", called, f.Len())
+	}
+	if err := f.Add("k", 2, 0, nil); err != nil {
+		t.Errorf("Add over expired entry: %v", err)
+	}
+}
+
+func TestFakeOps(t *testing.T) {
+	f := NewFake(start)
+	f.Add("k", 1, time.Minute, nil)
+	f.Add("k", 2, 0, nil)
+	f.Del("missing")
+	ops := f.Ops()
+	want := []Op{
+		{Name: "Add", Key: "k", Value: 1, TTL: time.Minute},
+		{Name: "Add", Key: "k", Value: 2},
+		{Name: "Del", Key: "missing"},
+	}
+	if len(ops) != len(want) {
+		t.Fatalf("Ops: got %d, want %d", len(ops), len(want))
+	}
+	for i, op := range ops {
+		w := want[i]
+		if op.Name != w.Name || op.Key != w.Key || op.Value != w.Value || op.TTL != w.TTL {
+			t.Errorf("op %d: got %+v, want %+v", i, op, w)
+		}
+	}
+	if ops[1].Err == nil || ops[1].Err.Error() != cachemap.ErrorKeyExist {
+		t.Errorf("op 1 error: got %v, want %s", ops[1].Err, cachemap.ErrorKeyExist)
+	}
+	if ops[2].Err == nil || ops[2].Err.Error() != cachemap.ErrorKeyNotFound {
+		t.Errorf("op 2 error: got %v, want %s", ops[2].Err, cachemap.ErrorKeyNotFound)
+	}
+	f.ResetOps()
+	if len(f.Ops()) != 0 {
+		t.Error("ResetOps did not clear the recorded operations")
+	}
+}
@@ -0,0 +1,25 @@
+package cachemap
+
+import "time"
+
+// 当前时间, 启用 CoarseClock 时返回后台协程定期更新的时间
+func (cm *cacheMap) now() time.Time {
+	if cm.coarseClock > 0 {
+		return cm.clock.Load().(time.Time)
+	}
+	return time.Now()
+}
+
+// 按 CoarseClock 的精度更新当前时间, Stop 后退出
+func (cm *cacheMap) runClock() {
+	ticker := time.NewTicker(cm.coarseClock)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cm.stopChan:
+			return
+		case t := <-ticker.C:
+			cm.clock.Store(t)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package cachemap
+
+import (
+	"testing"
+	"time"
+)
+
+// 启用 CoarseClock 时所有时间判断都使用同一个时钟, 时钟未更新前墓碑不会过期
+func TestCoarseClockTombstone(t *testing.T) {
+	m, err := New(Option{
+		SleepTime:         time.Hour,
+		CoarseClock:       time.Hour,
+		TombstoneDuration: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	if err := m.Add("k", 1, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Del("k"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !m.WasRecentlyDeleted("k") {
+		t.Error("tombstone expired by wall time while the coarse clock has not advanced")
+	}
+}
+
+func TestCoarseClockAddManaged(t *testing.T) {
+	m, err := New(Option{SleepTime: time.Hour, CoarseClock: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	now := m.now()
+	if err := m.AddManaged("k", 1, now.Add(time.Minute), nil); err != nil {
+		t.Fatal(err)
+	}
+	item, err := m.Get("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := item.UpdateTime.Add(item.TTL); !d.Equal(now.Add(time.Minute)) {
+		t.Errorf("deadline: got %v, want %v", d, now.Add(time.Minute))
+	}
+}
+
+// 创建以 CoarseClock 为时钟的 Map, 时钟只在调用 advance 时前进
+func newManualClock(t *testing.T, option Option) (CacheMap, func(d time.Duration)) {
+	t.Helper()
+	option.SleepTime = time.Hour
+	option.CoarseClock = time.Hour
+	m, err := New(option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Stop)
+	return m, func(d time.Duration) {
+		m.cacheMap.clock.Store(m.now().Add(d))
+	}
+}
+
+// 恰好在 UpdateTime+TTL 时仍未过期, 之后才过期, StateAt 与清理的判断一致
+func TestExpiryBoundary(t *testing.T) {
+	m, advance := newManualClock(t, Option{})
+	expired := 0
+	if err := m.Add("k", 1, time.Minute, func(CacheItem) { expired++ }); err != nil {
+		t.Fatal(err)
+	}
+	item, err := m.Get("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := item.UpdateTime.Add(item.TTL)
+	advance(time.Minute)
+	if now := m.now(); !now.Equal(deadline) {
+		t.Fatalf("clock: got %v, want %v", now, deadline)
+	}
+	if s := item.StateAt(deadline); s != EntryActive {
+		t.Errorf("StateAt(deadline): got %v, want %v", s, EntryActive)
+	}
+	m.sweep()
+	if !m.Has("k") || expired != 0 {
+		t.Fatalf("swept at exactly the deadline: Has %v, callbacks %d", m.Has("k"), expired)
+	}
+	advance(time.Nanosecond)
+	if s := item.StateAt(m.now()); s != EntryExpired {
+		t.Errorf("StateAt(deadline+1ns): got %v, want %v", s, EntryExpired)
+	}
+	if m.Has("k") {
+		t.Error("Has: got true after the deadline")
+	}
+	m.sweep()
+	if m.Len() != 0 || expired != 1 {
+		t.Errorf("after the deadline: Len %d, callbacks %d, want 0 and 1", m.Len(), expired)
+	}
+}
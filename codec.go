@@ -0,0 +1,71 @@
+package cachemap
+
+// 值的编解码器, 用于 SerializeValues
+// Unmarshal 需要自行还原出具体类型, 因此通常每个 Map 只存放一种类型的值
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+// 启用 SerializeValues 时实际存储的值类型
+type encodedValue []byte
+
+// 启用 SerializeValues 时将值编码后再存储, 启用 CopyBytes 时复制 []byte 值, 在锁外调用
+func (cm *cacheMap) encodeValue(value interface{}) (interface{}, error) {
+	if cm.codec == nil {
+		if b, ok := value.([]byte); ok && cm.copyBytes {
+			return copyBytes(b), nil
+		}
+		return value, nil
+	}
+	data, err := cm.codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return encodedValue(data), nil
+}
+
+// 还原编码存储的值, PushToList 创建的列表转换为未过期元素的 []ListElem
+// HSet 创建的字段集合转换为 map[interface{}]interface{} 副本, 其他未编码的值原样返回
+func (cm *cacheMap) decodeValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case listValue:
+		return cm.liveList(v, cm.now()), nil
+	case hashValue:
+		return cm.copyHash(v), nil
+	}
+	if b, ok := value.([]byte); ok && cm.copyBytes {
+		return copyBytes(b), nil
+	}
+	data, ok := value.(encodedValue)
+	if !ok || cm.codec == nil {
+		return value, nil
+	}
+	return cm.codec.Unmarshal(data)
+}
+
+// 启用 CopyBytes 时复制 []byte 值, 用于列表元素和字段等不经过 encodeValue 的值
+func (cm *cacheMap) copyValue(value interface{}) interface{} {
+	if b, ok := value.([]byte); ok && cm.copyBytes {
+		return copyBytes(b)
+	}
+	return value
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	n := make([]byte, len(b))
+	copy(n, b)
+	return n
+}
+
+// 返回值已解码的键值对副本, 解码失败时保留原始字节
+func (cm *cacheMap) view(item *CacheItem) CacheItem {
+	v := *item
+	if value, err := cm.decodeValue(v.Value); err == nil {
+		v.Value = value
+	}
+	return v
+}
@@ -0,0 +1,106 @@
+package cachemap
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type intCodec struct{}
+
+func (intCodec) Marshal(value interface{}) ([]byte, error) {
+	n, ok := value.(int)
+	if !ok {
+		return nil, errors.New("not an int")
+	}
+	return []byte(strconv.Itoa(n)), nil
+}
+
+func (intCodec) Unmarshal(data []byte) (interface{}, error) {
+	return strconv.Atoi(string(data))
+}
+
+func newSerializing(t *testing.T, opts Option) CacheMap {
+	opts.SerializeValues = true
+	opts.Codec = intCodec{}
+	m, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(m.Stop)
+	return m
+}
+
+func TestSerializeValuesReadPaths(t *testing.T) {
+	m := newSerializing(t, Option{SleepTime: time.Hour})
+	if err := m.Add("k", 42, time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	check := func(name string, item CacheItem) {
+		t.Helper()
+		if item.Value != 42 {
+			t.Errorf("%s: got %#v, want 42", name, item.Value)
+		}
+	}
+	item, err := m.Get("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("Get", item)
+	for name, items := range m.GroupBy(func(CacheItem) string { return "" }) {
+		check("GroupBy "+name, items[0])
+	}
+	if err := m.ForeachMatching("k*", func(item CacheItem) { check("ForeachMatching", item) }); err != nil {
+		t.Fatal(err)
+	}
+	check("SoonestToExpire", m.SoonestToExpire(1)[0])
+	check("ExpiringWithinItems", m.ExpiringWithinItems(2 * time.Hour)[0])
+	if ok, err := m.RenewIf("k", 42, time.Hour); err != nil || !ok {
+		t.Errorf("RenewIf: got %v, %v, want true", ok, err)
+	}
+	values := m.SnapshotAndReset(func(old interface{}) interface{} {
+		if old != 42 {
+			t.Errorf("SnapshotAndReset reset: got %#v, want 42", old)
+		}
+		return 7
+	})
+	if values["k"] != 42 {
+		t.Errorf("SnapshotAndReset: got %#v, want 42", values["k"])
+	}
+	if item, _ := m.Get("k"); item.Value != 7 {
+		t.Errorf("after SnapshotAndReset: got %#v, want 7", item.Value)
+	}
+	if err := m.SetValue("k", 42); err != nil {
+		t.Fatal(err)
+	}
+	item, ok := m.PopOldest()
+	if !ok {
+		t.Fatal("PopOldest: empty")
+	}
+	check("PopOldest", item)
+	if err := m.Add("k", 42, time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	check("Flush", m.Flush()[0])
+}
+
+func TestSerializeValuesCallbacks(t *testing.T) {
+	var batch []CacheItem
+	m := newSerializing(t, Option{
+		SleepTime:     time.Hour,
+		BatchCallback: func(items []CacheItem) { batch = append(batch, items...) },
+	})
+	var got interface{}
+	if err := m.Add("k", 42, time.Nanosecond, func(item CacheItem) { got = item.Value }); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	m.sweep()
+	if got != 42 {
+		t.Errorf("callFunc: got %#v, want 42", got)
+	}
+	if len(batch) != 1 || batch[0].Value != 42 {
+		t.Errorf("BatchCallback: got %v, want one item with value 42", batch)
+	}
+}
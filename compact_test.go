@@ -0,0 +1,105 @@
+package cachemap
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	for i := 0; i < 1000; i++ {
+		if err := m.Add(i, i, 0, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 10; i < 1000; i++ {
+		if err := m.Del(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Compact()
+	if m.Len() != 10 {
+		t.Errorf("Len: got %d, want 10", m.Len())
+	}
+	for i := 0; i < 10; i++ {
+		if item, err := m.Get(i); err != nil || item.Value != i {
+			t.Errorf("Get(%d): got %v, %v", i, item.Value, err)
+		}
+	}
+	if m.cacheMap.peak != 10 {
+		t.Errorf("peak: got %d, want 10", m.cacheMap.peak)
+	}
+	if err := m.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 清理后键值对数量低于峰值的 CompactRatio 倍时自动重建, 高于时不重建
+func TestCompactRatio(t *testing.T) {
+	m, err := New(Option{SleepTime: time.Hour, CompactRatio: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	for i := 0; i < 100; i++ {
+		ttl := time.Duration(0)
+		if i >= 60 {
+			ttl = time.Nanosecond
+		}
+		if err := m.Add(i, i, ttl, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond)
+	m.sweep()
+	if m.Len() != 60 || m.cacheMap.peak != 100 {
+		t.Fatalf("above ratio: Len %d, peak %d, want 60 and 100", m.Len(), m.cacheMap.peak)
+	}
+	for i := 10; i < 60; i++ {
+		if err := m.SetTTL(i, time.Nanosecond, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(time.Millisecond)
+	m.sweep()
+	if m.Len() != 10 || m.cacheMap.peak != 10 {
+		t.Fatalf("below ratio: Len %d, peak %d, want 10 and 10", m.Len(), m.cacheMap.peak)
+	}
+	if err := m.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 大量删除后 Compact 释放内部 map 占用的内存
+func TestCompactReleasesMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping memory measurement in short mode")
+	}
+	const n = 500000
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	for i := 0; i < n; i++ {
+		m.Add(i, i, 0, nil)
+	}
+	for i := 1000; i < n; i++ {
+		m.Del(i)
+	}
+	heap := func() uint64 {
+		var ms runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&ms)
+		return ms.HeapAlloc
+	}
+	before := heap()
+	m.Compact()
+	after := heap()
+	t.Logf("heap before Compact %d KiB, after %d KiB", before>>10, after>>10)
+	if after >= before/2 {
+		t.Errorf("Compact released too little: heap %d -> %d bytes", before, after)
+	}
+	if m.Len() != 1000 {
+		t.Errorf("Len: got %d, want 1000", m.Len())
+	}
+}
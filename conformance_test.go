@@ -0,0 +1,37 @@
+package cachemap_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yaotthaha/cachemap"
+	"github.com/yaotthaha/cachemap/cachemaptest"
+)
+
+// 在各种选项组合下检查 Map 满足 Cache 的一致性保证
+func TestConformance(t *testing.T) {
+	pool := cachemap.NewSweeperPool(2, time.Millisecond)
+	defer pool.Stop()
+	tests := []struct {
+		name   string
+		option cachemap.Option
+	}{
+		{"default", cachemap.Option{}},
+		{"SweeperPool", cachemap.Option{SweeperPool: pool}},
+		{"CoarseClock", cachemap.Option{CoarseClock: time.Millisecond}},
+		{"PoolItems", cachemap.Option{PoolItems: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cachemaptest.RunConformance(t, func(t *testing.T) cachemap.Cache {
+				option := tt.option
+				option.SleepTime = time.Millisecond
+				m, err := cachemap.New(option)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return m
+			})
+		})
+	}
+}
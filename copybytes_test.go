@@ -0,0 +1,81 @@
+package cachemap
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCopyBytes(t *testing.T) {
+	m, err := New(Option{CopyBytes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	b := []byte("abc")
+	if err := m.Add("k", b, time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	b[0] = 'x'
+	item, err := m.Get("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := item.Value.([]byte); !bytes.Equal(got, []byte("abc")) {
+		t.Fatalf("mutating the added slice changed the cache: got %q", got)
+	}
+	item.Value.([]byte)[0] = 'y'
+	for _, items := range m.GroupBy(func(CacheItem) string { return "" }) {
+		items[0].Value.([]byte)[1] = 'y'
+	}
+	m.Foreach(func(item CacheItem) {
+		item.Value.([]byte)[2] = 'y'
+	})
+	item, _ = m.Get("k")
+	if got := item.Value.([]byte); !bytes.Equal(got, []byte("abc")) {
+		t.Fatalf("mutating a returned slice changed the cache: got %q", got)
+	}
+}
+
+func TestCopyBytesHash(t *testing.T) {
+	m, err := New(Option{CopyBytes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	b := []byte("abc")
+	if err := m.HSet("k", "f", b); err != nil {
+		t.Fatal(err)
+	}
+	b[0] = 'x'
+	v, err := m.HGet("k", "f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.([]byte)[1] = 'y'
+	m.HGetAll("k")["f"].([]byte)[2] = 'y'
+	item, _ := m.Get("k")
+	item.Value.(map[interface{}]interface{})["f"].([]byte)[0] = 'y'
+	if v, _ := m.HGet("k", "f"); !bytes.Equal(v.([]byte), []byte("abc")) {
+		t.Fatalf("mutating a field slice changed the cache: got %q", v)
+	}
+}
+
+func TestCopyBytesList(t *testing.T) {
+	m, err := New(Option{CopyBytes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	b := []byte("abc")
+	if err := m.PushToList("k", b, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	b[0] = 'x'
+	m.GetList("k")[0].Value.([]byte)[1] = 'y'
+	item, _ := m.Get("k")
+	item.Value.([]ListElem)[0].Value.([]byte)[2] = 'y'
+	if got := m.GetList("k")[0].Value.([]byte); !bytes.Equal(got, []byte("abc")) {
+		t.Fatalf("mutating a list element slice changed the cache: got %q", got)
+	}
+}
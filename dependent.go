@@ -0,0 +1,108 @@
+package cachemap
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (cm *cacheMap) addDependent(key, value, parentKey interface{}, ttl time.Duration) error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	defer cm.flushBatchLocked()
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return err
+	}
+	_, pk, err := cm.mapKey(parentKey)
+	if err != nil {
+		return err
+	}
+	now := cm.now()
+	if _, ok := cm.liveLocked(pk, now); !ok {
+		return errors.New(ErrorKeyNotFound)
+	}
+	if _, ok := cm.liveLocked(k, now); ok {
+		return errors.New(ErrorKeyExist)
+	}
+	cm.insertLocked(k, cm.newItem(key, value, ttl, nil))
+	if cm.dependents == nil {
+		cm.dependents = make(map[interface{}][]interface{})
+		cm.dependsOn = make(map[interface{}]interface{})
+	}
+	cm.dependents[pk] = append(cm.dependents[pk], k)
+	cm.dependsOn[k] = pk
+	return nil
+}
+
+// 添加一个依赖于 parentKey 的键值对, ttl 为其自身的存活时间, 与父键值对无关
+// 父键值对被删除或过期被清理时, 依赖它的键值对随之按过期处理并调用唤醒函数等回调, 可以多层级联
+// 因此实际存活时间不超过父键值对, 父键值对通过 SetTTL 等续期后依赖它的键值对也随之延长
+// 键已存在时返回 ErrorKeyExist, 父键不存在或已过期时返回 ErrorKeyNotFound
+func (w *Map) AddDependent(key, value, parentKey interface{}, ttl time.Duration) error {
+	if err := w.checkValue(key, value); err != nil {
+		return err
+	}
+	ttl = w.ttlFor(key, value, ttl)
+	value, err := w.encodeValue(value)
+	if err != nil {
+		return err
+	}
+	return w.addDependent(key, value, parentKey, ttl)
+}
+
+// 键值对被移除后维护依赖索引, 并将依赖它的键值对按过期处理, 调用方需持有写锁
+func (cm *cacheMap) dropDependentsLocked(k interface{}) {
+	if p, ok := cm.dependsOn[k]; ok {
+		delete(cm.dependsOn, k)
+		list := cm.dependents[p]
+		for i, d := range list {
+			if d == k {
+				list = append(list[:i:i], list[i+1:]...)
+				break
+			}
+		}
+		if len(list) == 0 {
+			delete(cm.dependents, p)
+		} else {
+			cm.dependents[p] = list
+		}
+	}
+	deps, ok := cm.dependents[k]
+	if !ok {
+		return
+	}
+	delete(cm.dependents, k)
+	for _, d := range deps {
+		delete(cm.dependsOn, d)
+		if item, ok := cm.m[d]; ok {
+			cm.expireLocked(d, item)
+		}
+	}
+}
+
+// 检查依赖索引是否互为逆映射且只指向存在的键值对, 调用方需持有锁
+func (cm *cacheMap) checkDependentsLocked() error {
+	count := 0
+	for p, deps := range cm.dependents {
+		if _, ok := cm.m[p]; !ok {
+			return fmt.Errorf("dependent parent %v is not present", p)
+		}
+		if len(deps) == 0 {
+			return fmt.Errorf("dependent parent %v has no dependents", p)
+		}
+		for _, d := range deps {
+			if _, ok := cm.m[d]; !ok {
+				return fmt.Errorf("dependent %v of %v is not present", d, p)
+			}
+			if dp, ok := cm.dependsOn[d]; !ok || dp != p {
+				return fmt.Errorf("dependent %v of %v depends on %v", d, p, dp)
+			}
+		}
+		count += len(deps)
+	}
+	if count != len(cm.dependsOn) {
+		return fmt.Errorf("%d dependents indexed by parent but %d by key", count, len(cm.dependsOn))
+	}
+	return nil
+}
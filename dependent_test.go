@@ -0,0 +1,104 @@
+package cachemap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDependentCascade(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	if err := m.Add("parent", 1, 10*time.Millisecond, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddDependent("child", 2, "parent", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddDependent("grandchild", 3, "child", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if item, _ := m.Get("child"); item.TTL != 0 {
+		t.Errorf("child TTL: got %v, want its own TTL 0", item.TTL)
+	}
+	if err := m.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+	// 续期父键值对后, 依赖它的键值对不会在原到期时间被删除
+	if err := m.SetTTL("parent", time.Hour, true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	m.sweep()
+	if !m.Has("child") || !m.Has("grandchild") {
+		t.Fatal("dependents removed before their renewed parent expired")
+	}
+	if err := m.SetTTL("parent", time.Nanosecond, true); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	m.sweep()
+	for _, k := range []string{"parent", "child", "grandchild"} {
+		if m.Has(k) {
+			t.Errorf("%s still present after parent expired", k)
+		}
+	}
+	if err := m.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDependentDelParent(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	if err := m.Add("parent", 1, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddDependent("child", 2, "parent", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Del("parent"); err != nil {
+		t.Fatal(err)
+	}
+	if m.Has("child") {
+		t.Error("child still present after parent was deleted")
+	}
+	if err := m.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 父键值对到期后, 即使尚未被清理, 依赖它的键值对也按过期处理
+func TestDependentLazyExpiry(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	if err := m.Add("parent", 1, time.Millisecond, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddDependent("child", 2, "parent", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.AddDependent("grandchild", 3, "child", 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	for _, k := range []string{"child", "grandchild"} {
+		if m.Has(k) {
+			t.Errorf("Has(%s): got true after parent expired", k)
+		}
+		if s, err := m.State(k); err != nil || s != EntryExpired {
+			t.Errorf("State(%s): got %v, %v, want %v", k, s, err, EntryExpired)
+		}
+		if _, status := m.GetDetailed(k); status != StatusExpired {
+			t.Errorf("GetDetailed(%s): got %v, want %v", k, status, StatusExpired)
+		}
+	}
+	if n := len(m.items()); n != 0 {
+		t.Errorf("items: got %d entries, want 0", n)
+	}
+	if err := m.Add("child", 4, 0, nil); err != nil {
+		t.Errorf("Add over lazily expired dependent: %v", err)
+	}
+	if err := m.CheckInvariants(); err != nil {
+		t.Fatal(err)
+	}
+}
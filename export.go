@@ -0,0 +1,216 @@
+package cachemap
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"io"
+	"time"
+)
+
+// PushToList 创建的列表和 HSet 创建的字段集合按原类型导出, 导入后仍可继续使用相应的操作
+func init() {
+	gob.Register(listValue(nil))
+	gob.Register(hashValue(nil))
+}
+
+// 单个键值对的序列化格式, Remaining 为导出时的剩余存活时间
+type entryRecord struct {
+	Key        interface{}
+	Value      interface{}
+	TTL        time.Duration
+	Remaining  time.Duration
+	CreateTime time.Time
+}
+
+// 生成键值对的序列化格式, 调用方需持有锁
+func (cm *cacheMap) record(item *CacheItem, now time.Time) entryRecord {
+	value := item.Value
+	switch value.(type) {
+	case listValue, hashValue:
+	default:
+		value = cm.view(item).Value
+	}
+	record := entryRecord{
+		Key:        item.Key,
+		Value:      value,
+		TTL:        item.TTL,
+		CreateTime: item.CreateTime,
+	}
+	if item.TTL > 0 {
+		record.Remaining = item.deadline().Sub(now)
+	}
+	return record
+}
+
+func (cm *cacheMap) exportEntry(key interface{}) ([]byte, error) {
+	cm.lock.RLock()
+	_, k, err := cm.mapKey(key)
+	if err != nil {
+		cm.lock.RUnlock()
+		return nil, err
+	}
+	item, ok := cm.m[k]
+	now := cm.now()
+	if !ok || cm.stateAt(k, item, now) == EntryExpired {
+		cm.lock.RUnlock()
+		return nil, errors.New(ErrorKeyNotFound)
+	}
+	record := cm.record(item, now)
+	cm.lock.RUnlock()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&record); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 使用 gob 导出单个键值对, 包含剩余存活时间但不包含唤醒函数
+// 非内置类型的键和值需要事先通过 gob.Register 注册
+func (w *Map) ExportEntry(key interface{}) ([]byte, error) {
+	return w.exportEntry(key)
+}
+
+func (cm *cacheMap) importEntry(data []byte) error {
+	var record entryRecord
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&record); err != nil {
+		return err
+	}
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	return cm.restoreLocked(record)
+}
+
+// 按序列化格式添加键值对, 键已存在时返回错误, 调用方需持有写锁
+func (cm *cacheMap) restoreLocked(record entryRecord) error {
+	if err := cm.checkSizeLocked(record.Key, record.Value); err != nil {
+		return err
+	}
+	value := record.Value
+	switch value.(type) {
+	case listValue, hashValue:
+	default:
+		var err error
+		if value, err = cm.encodeValue(value); err != nil {
+			return err
+		}
+	}
+	key, k, err := cm.writeKey(record.Key)
+	if err != nil {
+		return err
+	}
+	if _, ok := cm.liveLocked(k, cm.now()); ok {
+		return errors.New(ErrorKeyExist)
+	}
+	item := cm.newItem(key, value, record.TTL, nil)
+	if record.TTL > 0 {
+		// 按剩余存活时间反推 UpdateTime, 使到期时间与导出时一致
+		item.UpdateTime = item.UpdateTime.Add(record.Remaining - record.TTL)
+	}
+	if !record.CreateTime.IsZero() {
+		item.CreateTime = record.CreateTime
+	}
+	cm.insertLocked(k, item)
+	return nil
+}
+
+// 导入由 ExportEntry 导出的键值对, 按剩余存活时间重新计算到期时间, 键已存在时返回错误
+func (w *Map) ImportEntry(data []byte) error {
+	return w.importEntry(data)
+}
+
+// 统计写入字节数的 io.Writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// 统计读取字节数的 io.Reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WriteTo 每次在读锁内读取的键值对数量
+const streamBatchSize = 1024
+
+func (cm *cacheMap) writeTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	enc := gob.NewEncoder(cw)
+	cm.lock.RLock()
+	keys := make([]interface{}, 0, len(cm.m))
+	for k := range cm.m {
+		keys = append(keys, k)
+	}
+	cm.lock.RUnlock()
+	records := make([]entryRecord, 0, streamBatchSize)
+	for len(keys) > 0 {
+		n := streamBatchSize
+		if n > len(keys) {
+			n = len(keys)
+		}
+		records = records[:0]
+		cm.lock.RLock()
+		now := cm.now()
+		for _, k := range keys[:n] {
+			if v, ok := cm.m[k]; ok && cm.stateAt(k, v, now) != EntryExpired {
+				records = append(records, cm.record(v, now))
+			}
+		}
+		cm.lock.RUnlock()
+		keys = keys[n:]
+		for i := range records {
+			if err := enc.Encode(&records[i]); err != nil {
+				return cw.n, err
+			}
+		}
+	}
+	return cw.n, nil
+}
+
+// 使用 gob 将所有未过期的键值对逐个写入 w, 格式与 ExportEntry 相同但共用同一个 gob 流
+// 与 ForeachBatch 一样分批在读锁内读取, 内存占用有上限, 但结果不是一致的快照
+func (w *Map) WriteTo(wr io.Writer) (int64, error) {
+	return w.writeTo(wr)
+}
+
+func (cm *cacheMap) readFrom(r io.Reader) (int64, error) {
+	cr := &countingReader{r: r}
+	dec := gob.NewDecoder(cr)
+	for {
+		var record entryRecord
+		if err := dec.Decode(&record); err != nil {
+			if err == io.EOF {
+				return cr.n, nil
+			}
+			return cr.n, err
+		}
+		if record.TTL > 0 && record.Remaining <= 0 {
+			continue
+		}
+		cm.lock.Lock()
+		err := cm.restoreLocked(record)
+		cm.lock.Unlock()
+		if err != nil && err.Error() != ErrorKeyExist {
+			return cr.n, err
+		}
+	}
+}
+
+// 逐个读取由 WriteTo 写入的键值对并添加, 按剩余存活时间重新计算到期时间
+// 已存在的键保留原值, 导出后已到期的键值对被跳过
+func (w *Map) ReadFrom(r io.Reader) (int64, error) {
+	return w.readFrom(r)
+}
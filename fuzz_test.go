@@ -0,0 +1,178 @@
+package cachemap
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+type keyWithSlice struct {
+	S []byte
+}
+
+type keyWithInterface struct {
+	A int
+	V interface{}
+}
+
+type keyNested struct {
+	Name  string
+	Inner keyWithInterface
+}
+
+// 根据 data 构造各种形状的键, 首字节选择形状, 其余字节作为内容
+func fuzzKey(data []byte) interface{} {
+	if len(data) == 0 {
+		return ""
+	}
+	rest := data[1:]
+	var dyn interface{} = string(rest)
+	if len(rest)%2 == 1 {
+		dyn = rest
+	}
+	switch data[0] % 10 {
+	case 0:
+		return nil
+	case 1:
+		return string(rest)
+	case 2:
+		return len(rest)
+	case 3:
+		return struct {
+			A int
+			B string
+		}{len(rest), string(rest)}
+	case 4:
+		return keyWithInterface{len(rest), dyn}
+	case 5:
+		return keyWithSlice{rest}
+	case 6:
+		return [2]interface{}{len(rest), dyn}
+	case 7:
+		return keyNested{string(rest), keyWithInterface{V: dyn}}
+	case 8:
+		return map[string]int{string(rest): 1}
+	default:
+		return rest
+	}
+}
+
+func FuzzAddKey(f *testing.F) {
+	f.Add([]byte("\x00"))
+	f.Add([]byte("\x05abc"))
+	f.Add([]byte("\x04ab"))
+	f.Add([]byte("\x07abc"))
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		key := fuzzKey(data)
+		_, valid := CheckKeyType(key)
+		err := m.Add(key, 1, time.Hour, nil)
+		if !valid {
+			if err == nil || !strings.HasPrefix(err.Error(), ErrorInvalidKeyType) {
+				t.Fatalf("Add(%#v): got %v, want %s", key, err, ErrorInvalidKeyType)
+			}
+			return
+		}
+		if err != nil && err.Error() != ErrorKeyExist {
+			t.Fatalf("Add(%#v): %v", key, err)
+		}
+		if _, err := m.Get(key); err != nil {
+			t.Fatalf("Get(%#v): %v", key, err)
+		}
+		if err := m.Del(key); err != nil {
+			t.Fatalf("Del(%#v): %v", key, err)
+		}
+	})
+}
+
+// 每两个字节为一次操作, 第一个字节选择操作, 第二个字节选择键和值, 与模型 map 的结果比较
+func FuzzOps(f *testing.F) {
+	f.Add([]byte("\x00\x01\x01\x01\x02\x01\x01\x01"))
+	f.Add([]byte("\x00\x01\x03\x01\x04\x00\x00\x01\x05\x00\x00\x02"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		done := make(chan error, 1)
+		go func() {
+			done <- fuzzOps(data)
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("operations did not finish, possible deadlock: %q", data)
+		}
+	})
+}
+
+func fuzzOps(data []byte) error {
+	m := NewCacheMap(Option{SleepTime: time.Millisecond})
+	defer m.Stop()
+	model := make(map[int]int)
+	errIs := func(err error, want string) bool {
+		if want == "" {
+			return err == nil
+		}
+		return err != nil && err.Error() == want
+	}
+	want := func(ok bool) string {
+		if ok {
+			return ""
+		}
+		return ErrorKeyNotFound
+	}
+	for i := 0; i+1 < len(data); i += 2 {
+		op, arg := data[i]%6, int(data[i+1])
+		key, value := arg%8, arg
+		_, exists := model[key]
+		var err error
+		switch op {
+		case 0:
+			err = m.Add(key, value, time.Hour, nil)
+			wantErr := ""
+			if exists {
+				wantErr = ErrorKeyExist
+			} else {
+				model[key] = value
+			}
+			if !errIs(err, wantErr) {
+				return fmt.Errorf("op %d Add(%d): got %v, want %q", i/2, key, err, wantErr)
+			}
+		case 1:
+			var item CacheItem
+			item, err = m.Get(key)
+			if !errIs(err, want(exists)) {
+				return fmt.Errorf("op %d Get(%d): got %v, want %q", i/2, key, err, want(exists))
+			}
+			if exists && item.Value != model[key] {
+				return fmt.Errorf("op %d Get(%d): got value %v, want %d", i/2, key, item.Value, model[key])
+			}
+		case 2:
+			err = m.Del(key)
+			delete(model, key)
+			if !errIs(err, want(exists)) {
+				return fmt.Errorf("op %d Del(%d): got %v, want %q", i/2, key, err, want(exists))
+			}
+		case 3:
+			ttl := time.Duration(0)
+			if arg%2 == 1 {
+				ttl = time.Hour
+			}
+			err = m.SetTTL(key, ttl, arg%4 >= 2)
+			if !errIs(err, want(exists)) {
+				return fmt.Errorf("op %d SetTTL(%d): got %v, want %q", i/2, key, err, want(exists))
+			}
+		case 4:
+			m.Clear()
+			model = make(map[int]int)
+		case 5:
+			m.Stop()
+		}
+		if n := m.Len(); n != len(model) {
+			return fmt.Errorf("op %d: Len got %d, want %d", i/2, n, len(model))
+		}
+	}
+	return nil
+}
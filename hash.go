@@ -0,0 +1,168 @@
+package cachemap
+
+import (
+	"errors"
+	"fmt"
+)
+
+const ErrorFieldNotFound = "field not found"
+
+// HSet 存储的值类型, 通过 Get 等读取时返回的是 map[interface{}]interface{} 副本
+type hashValue map[interface{}]interface{}
+
+// 返回字段集合的副本, 启用 CopyBytes 时同时复制 []byte 值
+func (cm *cacheMap) copyHash(h hashValue) map[interface{}]interface{} {
+	m := make(map[interface{}]interface{}, len(h))
+	for f, v := range h {
+		m[f] = cm.copyValue(v)
+	}
+	return m
+}
+
+func checkField(field interface{}) error {
+	if tp, ok := CheckKeyType(field); !ok {
+		return errors.New(fmt.Sprintf(ErrorInvalidKeyType+": %s", tp))
+	}
+	return nil
+}
+
+func (cm *cacheMap) hSet(key, field, value interface{}) error {
+	if err := checkField(field); err != nil {
+		return err
+	}
+	if err := cm.checkNil(value); err != nil {
+		return err
+	}
+	value = cm.copyValue(value)
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return err
+	}
+	now := cm.now()
+	item, ok := cm.liveLocked(k, now)
+	if !ok {
+		h := hashValue{field: value}
+		if err := cm.checkSizeLocked(key, h); err != nil {
+			return err
+		}
+		cm.insertLocked(k, cm.newItem(key, h, 0, nil))
+		return nil
+	}
+	h, ok := item.Value.(hashValue)
+	if !ok {
+		return errors.New(ErrorWrongType)
+	}
+	n := make(hashValue, len(h)+1)
+	for f, v := range h {
+		n[f] = v
+	}
+	n[field] = value
+	if err := cm.checkSizeLocked(key, n); err != nil {
+		return err
+	}
+	item = cm.cloneItem(item)
+	cm.assign(item, n)
+	item.UpdateTime = now
+	cm.m[k] = item
+	return nil
+}
+
+// 设置键下某个字段的值, 键不存在时创建一个没有 TTL 的新键值对, 已有值不是 HSet 创建的时返回错误
+// TTL 作用于整个键, 可通过 SetTTL 设置, 写入字段会重置 UpdateTime, 字段值不经过 Validator 校验
+func (w *Map) HSet(key, field, value interface{}) error {
+	return w.hSet(key, field, value)
+}
+
+// 查找键对应的字段集合, 调用方需持有读锁
+func (cm *cacheMap) hashLocked(key interface{}) (hashValue, error) {
+	_, k, err := cm.mapKey(key)
+	if err != nil {
+		return nil, err
+	}
+	item, ok := cm.m[k]
+	if !ok || cm.stateAt(k, item, cm.now()) == EntryExpired {
+		return nil, errors.New(ErrorKeyNotFound)
+	}
+	h, ok := item.Value.(hashValue)
+	if !ok {
+		return nil, errors.New(ErrorWrongType)
+	}
+	return h, nil
+}
+
+func (cm *cacheMap) hGet(key, field interface{}) (interface{}, error) {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	h, err := cm.hashLocked(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkField(field); err != nil {
+		return nil, err
+	}
+	v, ok := h[field]
+	if !ok {
+		return nil, errors.New(ErrorFieldNotFound)
+	}
+	return cm.copyValue(v), nil
+}
+
+// 获取键下某个字段的值, 值不是 HSet 创建的时返回 ErrorWrongType, 字段不存在时返回 ErrorFieldNotFound
+func (w *Map) HGet(key, field interface{}) (interface{}, error) {
+	return w.hGet(key, field)
+}
+
+func (cm *cacheMap) hDel(key, field interface{}) error {
+	if err := checkField(field); err != nil {
+		return err
+	}
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	_, k, err := cm.writeKey(key)
+	if err != nil {
+		return err
+	}
+	item, ok := cm.liveLocked(k, cm.now())
+	if !ok {
+		return errors.New(ErrorKeyNotFound)
+	}
+	h, ok := item.Value.(hashValue)
+	if !ok {
+		return errors.New(ErrorWrongType)
+	}
+	if _, ok := h[field]; !ok {
+		return errors.New(ErrorFieldNotFound)
+	}
+	n := make(hashValue, len(h))
+	for f, v := range h {
+		if f != field {
+			n[f] = v
+		}
+	}
+	item = cm.cloneItem(item)
+	cm.assign(item, n)
+	cm.m[k] = item
+	return nil
+}
+
+// 删除键下的某个字段, 删除最后一个字段后键本身仍保留, 需要时通过 Del 删除
+func (w *Map) HDel(key, field interface{}) error {
+	return w.hDel(key, field)
+}
+
+func (cm *cacheMap) hGetAll(key interface{}) map[interface{}]interface{} {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	h, err := cm.hashLocked(key)
+	if err != nil {
+		return nil
+	}
+	return cm.copyHash(h)
+}
+
+// 获取键下所有字段的副本, 键不存在或值不是 HSet 创建的时返回 nil
+func (w *Map) HGetAll(key interface{}) map[interface{}]interface{} {
+	return w.hGetAll(key)
+}
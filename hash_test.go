@@ -0,0 +1,46 @@
+package cachemap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHashGetReturnsMap(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	if err := m.HSet("k", "f", 1); err != nil {
+		t.Fatal(err)
+	}
+	item, err := m.Get("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields, ok := item.Value.(map[interface{}]interface{})
+	if !ok {
+		t.Fatalf("Get: got %T, want map[interface{}]interface{}", item.Value)
+	}
+	fields["f"] = 2
+	if v, err := m.HGet("k", "f"); err != nil || v != 1 {
+		t.Errorf("modifying the returned map changed the fields: got %v, %v", v, err)
+	}
+}
+
+func TestHashExportImport(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	if err := m.HSet("k", "f", 1); err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.ExportEntry("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewCacheMap(Option{SleepTime: time.Hour})
+	defer n.Stop()
+	if err := n.ImportEntry(data); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := n.HGet("k", "f"); err != nil || v != 1 {
+		t.Errorf("HGet after import: got %v, %v, want 1", v, err)
+	}
+}
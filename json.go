@@ -0,0 +1,57 @@
+package cachemap
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// CacheItem 的 JSON 格式, TTL 为 time.Duration 的字符串形式, 时间为 RFC3339 格式
+type cacheItemJSON struct {
+	Key        interface{} `json:"key"`
+	Value      interface{} `json:"value"`
+	TTL        string      `json:"ttl"`
+	UpdateTime time.Time   `json:"update_time"`
+	CreateTime time.Time   `json:"create_time"`
+	ExpiresAt  *time.Time  `json:"expires_at,omitempty"`
+	Hash       string      `json:"hash,omitempty"`
+}
+
+func (item CacheItem) MarshalJSON() ([]byte, error) {
+	j := cacheItemJSON{
+		Key:        item.Key,
+		Value:      item.Value,
+		TTL:        item.TTL.String(),
+		UpdateTime: item.UpdateTime,
+		CreateTime: item.CreateTime,
+		Hash:       item.Hash,
+	}
+	if item.TTL > 0 {
+		expiresAt := item.deadline()
+		j.ExpiresAt = &expiresAt
+	}
+	return json.Marshal(j)
+}
+
+// expires_at 由 update_time 和 ttl 推导, 解析时忽略
+func (item *CacheItem) UnmarshalJSON(data []byte) error {
+	var j cacheItemJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if j.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(j.TTL); err != nil {
+			return err
+		}
+	}
+	*item = CacheItem{
+		Key:        j.Key,
+		Value:      j.Value,
+		TTL:        ttl,
+		UpdateTime: j.UpdateTime,
+		CreateTime: j.CreateTime,
+		Hash:       j.Hash,
+	}
+	return nil
+}
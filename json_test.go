@@ -0,0 +1,63 @@
+package cachemap
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheItemJSONRoundTrip(t *testing.T) {
+	update := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	item := CacheItem{
+		Key:        "k",
+		Value:      "v",
+		TTL:        5 * time.Minute,
+		UpdateTime: update,
+		CreateTime: update.Add(-time.Hour),
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"key":"k"`,
+		`"value":"v"`,
+		`"ttl":"5m0s"`,
+		`"update_time":"2024-01-02T03:04:05Z"`,
+		`"create_time":"2024-01-02T02:04:05Z"`,
+		`"expires_at":"2024-01-02T03:09:05Z"`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("%s: missing %s", data, want)
+		}
+	}
+	var got CacheItem
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != item.Key || got.Value != item.Value || got.TTL != item.TTL ||
+		!got.UpdateTime.Equal(item.UpdateTime) || !got.CreateTime.Equal(item.CreateTime) {
+		t.Errorf("round trip: got %+v, want %+v", got, item)
+	}
+}
+
+func TestCacheItemJSONNoTTL(t *testing.T) {
+	data, err := json.Marshal(CacheItem{Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "expires_at") {
+		t.Errorf("%s: expires_at set for an entry without TTL", data)
+	}
+	var got CacheItem
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.TTL != 0 {
+		t.Errorf("TTL: got %v, want 0", got.TTL)
+	}
+	if err := json.Unmarshal([]byte(`{"ttl":"soon"}`), &got); err == nil {
+		t.Error("invalid ttl: got nil error")
+	}
+}
@@ -0,0 +1,124 @@
+package cachemap
+
+import (
+	"errors"
+	"time"
+)
+
+// 列表中的单个元素, TTL 大于 0 时元素在 AddTime+TTL 后过期
+type ListElem struct {
+	Value   interface{}
+	AddTime time.Time
+	TTL     time.Duration
+}
+
+func (e ListElem) expiredAt(now time.Time) bool {
+	return e.TTL > 0 && now.Sub(e.AddTime) > e.TTL
+}
+
+// PushToList 存储的值类型, 通过 Get 等读取时返回的是只含未过期元素的 []ListElem 副本
+type listValue []ListElem
+
+// 返回未过期的元素, 结果为新分配的切片
+func (l listValue) live(now time.Time) []ListElem {
+	elems := make([]ListElem, 0, len(l))
+	for _, e := range l {
+		if !e.expiredAt(now) {
+			elems = append(elems, e)
+		}
+	}
+	return elems
+}
+
+func (cm *cacheMap) pushToList(key, elem interface{}, elemTTL time.Duration, maxLen int) error {
+	if err := cm.checkNil(elem); err != nil {
+		return err
+	}
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return err
+	}
+	now := cm.now()
+	e := ListElem{Value: cm.copyValue(elem), AddTime: now, TTL: elemTTL}
+	item, ok := cm.liveLocked(k, now)
+	if !ok {
+		l := listValue{e}
+		if err := cm.checkSizeLocked(key, l); err != nil {
+			return err
+		}
+		cm.insertLocked(k, cm.newItem(key, l, 0, nil))
+		return nil
+	}
+	l, ok := item.Value.(listValue)
+	if !ok {
+		return errors.New(ErrorWrongType)
+	}
+	elems := append(l.live(now), e)
+	if maxLen > 0 && len(elems) > maxLen {
+		elems = elems[len(elems)-maxLen:]
+	}
+	if err := cm.checkSizeLocked(key, listValue(elems)); err != nil {
+		return err
+	}
+	item = cm.cloneItem(item)
+	cm.assign(item, listValue(elems))
+	item.UpdateTime = now
+	cm.m[k] = item
+	return nil
+}
+
+// 向键对应的列表末尾追加一个元素, elemTTL 为该元素的存活时间, 0 为永不过期
+// maxLen 大于 0 时超出部分从最旧的元素开始丢弃, 键不存在时创建新列表, 已有值不是列表时返回错误
+// 列表本身没有 TTL, 所有元素均过期后由清理协程删除, 元素不经过 Validator 校验
+func (w *Map) PushToList(key, elem interface{}, elemTTL time.Duration, maxLen int) error {
+	return w.pushToList(key, elem, elemTTL, maxLen)
+}
+
+func (cm *cacheMap) getList(key interface{}) []ListElem {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	_, k, err := cm.mapKey(key)
+	if err != nil {
+		return nil
+	}
+	item, ok := cm.m[k]
+	now := cm.now()
+	if !ok || cm.stateAt(k, item, now) == EntryExpired {
+		return nil
+	}
+	l, ok := item.Value.(listValue)
+	if !ok {
+		return nil
+	}
+	return cm.liveList(l, now)
+}
+
+// 返回未过期元素的副本, 启用 CopyBytes 时同时复制 []byte 值
+func (cm *cacheMap) liveList(l listValue, now time.Time) []ListElem {
+	elems := l.live(now)
+	for i := range elems {
+		elems[i].Value = cm.copyValue(elems[i].Value)
+	}
+	return elems
+}
+
+// 获取键对应列表中未过期的元素, 按添加顺序排列, 键不存在或值不是列表时返回 nil
+func (w *Map) GetList(key interface{}) []ListElem {
+	return w.getList(key)
+}
+
+// 列表中是否已没有未过期的元素
+func (item *CacheItem) emptyListAt(now time.Time) bool {
+	l, ok := item.Value.(listValue)
+	if !ok {
+		return false
+	}
+	for _, e := range l {
+		if !e.expiredAt(now) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package cachemap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestListGetReturnsElems(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	if err := m.PushToList("k", "a", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PushToList("k", "b", time.Nanosecond, 0); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	item, err := m.Get("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	elems, ok := item.Value.([]ListElem)
+	if !ok {
+		t.Fatalf("Get: got %T, want []ListElem", item.Value)
+	}
+	if len(elems) != 1 || elems[0].Value != "a" {
+		t.Fatalf("Get: got %v, want only the live element a", elems)
+	}
+	elems[0].Value = "x"
+	if got := m.GetList("k"); got[0].Value != "a" {
+		t.Errorf("modifying the returned slice changed the list: got %v", got[0].Value)
+	}
+}
+
+func TestListExportImport(t *testing.T) {
+	m := NewCacheMap(Option{SleepTime: time.Hour})
+	defer m.Stop()
+	if err := m.PushToList("k", "a", 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.ExportEntry("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := NewCacheMap(Option{SleepTime: time.Hour})
+	defer n.Stop()
+	if err := n.ImportEntry(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.PushToList("k", "b", 0, 0); err != nil {
+		t.Fatalf("PushToList after import: %v", err)
+	}
+	if got := n.GetList("k"); len(got) != 2 {
+		t.Errorf("GetList after import: got %v, want 2 elements", got)
+	}
+}
@@ -0,0 +1,60 @@
+package cachemap
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckOptions(t *testing.T) {
+	sizer := func(interface{}) int64 { return 0 }
+	onExpire := func(CacheItem) {}
+	pool1, pool2 := NewSweeperPool(1, time.Hour), NewSweeperPool(1, time.Hour)
+	defer pool1.Stop()
+	defer pool2.Stop()
+	tests := []struct {
+		name    string
+		options []Option
+		wantErr string
+	}{
+		{"empty", nil, ""},
+		{"negative SleepTime", []Option{{SleepTime: -time.Second}}, "SleepTime"},
+		{"SerializeValues without Codec", []Option{{SerializeValues: true}}, "without Codec"},
+		{"Codec without SerializeValues", []Option{{Codec: intCodec{}}}, "without SerializeValues"},
+		{"SerializeValues with Codec", []Option{{SerializeValues: true, Codec: intCodec{}}}, ""},
+		{"Sizer without MaxValueBytes", []Option{{Sizer: sizer}}, "without MaxValueBytes"},
+		{"OnValueTooLarge without MaxValueBytes", []Option{{OnValueTooLarge: func(interface{}, int64) {}}}, "without MaxValueBytes"},
+		{"Sizer with MaxValueBytes in another option", []Option{{Sizer: sizer}, {MaxValueBytes: 10}}, ""},
+		{"AddOverwrites with AddRefreshesExisting", []Option{{AddOverwrites: true}, {AddRefreshesExisting: true}}, "conflicts"},
+		{"negative TombstoneDuration", []Option{{TombstoneDuration: -time.Second}}, "TombstoneDuration"},
+		{"negative SweepStartJitter", []Option{{SweepStartJitter: -time.Second}}, "SweepStartJitter"},
+		{"negative MaxValueBytes", []Option{{MaxValueBytes: -1}}, "MaxValueBytes"},
+		{"negative CoarseClock", []Option{{CoarseClock: -time.Second}}, "CoarseClock"},
+		{"GlobalExpireMode without OnExpire", []Option{{GlobalExpireMode: GlobalExpireSupplement}}, "without OnExpire"},
+		{"GlobalExpireMode with OnExpire", []Option{{GlobalExpireMode: GlobalExpireSupplement, OnExpire: onExpire}}, ""},
+		{"unknown GlobalExpireMode", []Option{{GlobalExpireMode: GlobalExpireSupplement + 1, OnExpire: onExpire}}, "unknown GlobalExpireMode"},
+		{"negative GlobalExpireMode", []Option{{GlobalExpireMode: -1, OnExpire: onExpire}}, "unknown GlobalExpireMode"},
+		{"negative CompactRatio", []Option{{CompactRatio: -0.1}}, "CompactRatio"},
+		{"CompactRatio of 1", []Option{{CompactRatio: 1}}, "CompactRatio"},
+		{"CompactRatio in range", []Option{{CompactRatio: 0.5}}, ""},
+		{"AddRefreshesTTL without AddRefreshesExisting", []Option{{AddRefreshesTTL: true}}, "without AddRefreshesExisting"},
+		{"BatchCallbackOnly without BatchCallback", []Option{{BatchCallbackOnly: true}}, "without BatchCallback"},
+		{"different SweeperPools", []Option{{SweeperPool: pool1}, {SweeperPool: pool2}}, "SweeperPool"},
+		{"same SweeperPool twice", []Option{{SweeperPool: pool1}, {SweeperPool: pool1}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := New(tt.options...)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got %v, want nil", err)
+				}
+				m.Stop()
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), ErrorInvalidOption) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got %v, want %s containing %q", err, ErrorInvalidOption, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package cachemap
+
+import (
+	"sync"
+	"time"
+)
+
+// 作用域, 记录通过它创建的键值对, Release 时将其全部删除, 适用于单次请求内的缓存
+// 键值对仍存放在所属的 Map 中, 对其他调用方可见, 未调用 Release 时也会正常过期
+type Scope struct {
+	w    *Map
+	lock sync.Mutex
+	// 实际存储用的键到插入序号, 用于确认键值对未被删除后重新添加
+	created map[interface{}]uint64
+}
+
+// 创建一个作用域
+func (w *Map) Scope() *Scope {
+	return &Scope{
+		w:       w,
+		created: make(map[interface{}]uint64),
+	}
+}
+
+// 在写锁内调用 write 写入键值对, write 返回 true 时记录新建的键值对
+// 写入和读取插入序号在同一次加锁内完成, 以免记录到其他调用方在两者之间写入的键值对
+func (s *Scope) write(key, value interface{}, ttl time.Duration, write func(key, k, value interface{}, ttl time.Duration) (bool, error)) error {
+	cm := s.w.cacheMap
+	if err := cm.checkValue(key, value); err != nil {
+		return err
+	}
+	ttl = cm.ttlFor(key, value, ttl)
+	value, err := cm.encodeValue(value)
+	if err != nil {
+		return err
+	}
+	cm.lock.Lock()
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		cm.lock.Unlock()
+		return err
+	}
+	created, err := write(key, k, value, ttl)
+	var seq uint64
+	if created {
+		seq = cm.m[k].seq
+	}
+	cm.lock.Unlock()
+	if err != nil || !created {
+		return err
+	}
+	s.lock.Lock()
+	s.created[k] = seq
+	s.lock.Unlock()
+	return nil
+}
+
+// 同 Map.Add, 仅在新建键值对时记录, 设置 AddOverwrites 或 AddRefreshesExisting 时已有的键值对不会被记录
+func (s *Scope) Add(key, value interface{}, ttl time.Duration, callFunc CallFuncType) error {
+	cm := s.w.cacheMap
+	return s.write(key, value, ttl, func(key, k, value interface{}, ttl time.Duration) (bool, error) {
+		return cm.addLocked(key, k, value, ttl, callFunc, nil)
+	})
+}
+
+// 同 Map.Set, 仅在新建键值对而不是覆盖已有键值对时记录
+func (s *Scope) Set(key, value interface{}, ttl time.Duration, callFunc CallFuncType) error {
+	cm := s.w.cacheMap
+	return s.write(key, value, ttl, func(key, k, value interface{}, ttl time.Duration) (bool, error) {
+		_, replaced := cm.setLocked(key, k, value, ttl, callFunc, false)
+		return !replaced, nil
+	})
+}
+
+// 同 Map.Get
+func (s *Scope) Get(key interface{}) (CacheItem, error) {
+	return s.w.Get(key)
+}
+
+// 不再记录该键, Release 时保留它, 返回该键此前是否由本作用域创建
+func (s *Scope) Promote(key interface{}) bool {
+	cm := s.w.cacheMap
+	cm.lock.RLock()
+	_, k, err := cm.mapKey(key)
+	cm.lock.RUnlock()
+	if err != nil {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.created[k]; !ok {
+		return false
+	}
+	delete(s.created, k)
+	return true
+}
+
+// 删除所有由本作用域创建且未被 Promote 的键值对, 不会调用唤醒函数, 返回删除的数量
+// 已被删除后又由其他调用方重新添加的键不受影响, 之后作用域可继续使用
+func (s *Scope) Release() int {
+	s.lock.Lock()
+	created := s.created
+	s.created = make(map[interface{}]uint64)
+	s.lock.Unlock()
+	cm := s.w.cacheMap
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	defer cm.flushBatchLocked()
+	count := 0
+	for k, seq := range created {
+		item, ok := cm.m[k]
+		if !ok || item.seq != seq {
+			continue
+		}
+		cm.removeLocked(k)
+		cm.releaseItem(item)
+		if cm.tombstoneDuration > 0 {
+			cm.tombstones[k] = cm.now()
+		}
+		count++
+	}
+	return count
+}
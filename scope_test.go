@@ -0,0 +1,64 @@
+package cachemap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScopeTracksOnlyCreated(t *testing.T) {
+	m, err := New(Option{AddRefreshesExisting: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	if err := m.Add("shared", 1, time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.Set("set", 1, time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	s := m.Scope()
+	if err := s.Add("shared", 2, time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("set", 2, time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Add("own", 1, time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("own-set", 1, time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Release(); n != 2 {
+		t.Errorf("Release: got %d, want 2", n)
+	}
+	for _, key := range []string{"shared", "set"} {
+		if !m.Has(key) {
+			t.Errorf("%s: existing entry removed by Release", key)
+		}
+	}
+	for _, key := range []string{"own", "own-set"} {
+		if m.Has(key) {
+			t.Errorf("%s: created entry kept after Release", key)
+		}
+	}
+}
+
+func TestScopeAddExisting(t *testing.T) {
+	m := NewCacheMap()
+	defer m.Stop()
+	if err := m.Add("k", 1, time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	s := m.Scope()
+	if err := s.Add("k", 2, time.Hour, nil); err == nil || err.Error() != ErrorKeyExist {
+		t.Fatalf("Add existing: got %v, want %s", err, ErrorKeyExist)
+	}
+	if n := s.Release(); n != 0 {
+		t.Errorf("Release: got %d, want 0", n)
+	}
+	if !m.Has("k") {
+		t.Error("existing entry removed by Release")
+	}
+}
@@ -0,0 +1,28 @@
+package cachemap
+
+import (
+	"context"
+)
+
+// 关闭 Map: 拒绝新的写入, 等待进行中的操作完成, 清理过期键值对并调用其唤醒函数, 最后停止清理协程
+// ctx 结束时立即返回 ctx.Err(), 剩余步骤仍会在后台完成
+// 拒绝的写入指所有添加键值对或修改值, TTL, 回调和别名的操作, 它们返回 ErrorClosed, GetOrAddPointer 返回 nil,
+// SnapshotAndReset 只读取而不重置; 只删除键值对的操作 (Del, Clear, DelPrefix, Flush, PopOldest, Scope.Release
+// 和 Transaction 中的 Del) 仍然生效, 以便调用方在关闭后清空 Map
+func (w *Map) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.lock.Lock()
+		w.closed = true
+		w.lock.Unlock()
+		w.sweep()
+		w.Stop()
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
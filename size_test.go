@@ -0,0 +1,87 @@
+package cachemap
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// MaxValueBytes 检查的是实际存储的值, 而不只是本次写入的部分
+func TestMaxValueBytesStoredValue(t *testing.T) {
+	concat := func(existing, incoming interface{}) interface{} {
+		return existing.(string) + incoming.(string)
+	}
+	tests := []struct {
+		name  string
+		write func(m CacheMap) error
+	}{
+		{"Merge", func(m CacheMap) error {
+			return m.Merge("k", "abc", concat, 0)
+		}},
+		{"HSet", func(m CacheMap) error {
+			return m.HSet("k", fmt.Sprint("f", len(m.HGetAll("k"))), "abc")
+		}},
+		{"PushToList", func(m CacheMap) error {
+			return m.PushToList("k", "abc", 0, 0)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rejected []int64
+			m, err := New(Option{
+				SleepTime:       time.Hour,
+				MaxValueBytes:   5,
+				OnValueTooLarge: func(key interface{}, size int64) { rejected = append(rejected, size) },
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Stop()
+			if err := tt.write(m); err != nil {
+				t.Fatalf("first write: %v", err)
+			}
+			err = tt.write(m)
+			if err == nil || !strings.HasPrefix(err.Error(), ErrorValueTooLarge) {
+				t.Fatalf("second write: got %v, want %s", err, ErrorValueTooLarge)
+			}
+			if m.RejectedValues() != 1 || len(rejected) != 1 {
+				t.Errorf("rejected: counter %d, callback %v", m.RejectedValues(), rejected)
+			}
+			if !m.Has("k") {
+				t.Error("rejected write removed the existing value")
+			}
+		})
+	}
+}
+
+func TestMaxValueBytesImport(t *testing.T) {
+	src := NewCacheMap(Option{SleepTime: time.Hour})
+	defer src.Stop()
+	if err := src.Add("k", "abcdef", time.Hour, nil); err != nil {
+		t.Fatal(err)
+	}
+	data, err := src.ExportEntry("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := src.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(Option{SleepTime: time.Hour, MaxValueBytes: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+	if err := m.ImportEntry(data); err == nil || !strings.HasPrefix(err.Error(), ErrorValueTooLarge) {
+		t.Errorf("ImportEntry: got %v, want %s", err, ErrorValueTooLarge)
+	}
+	if _, err := m.ReadFrom(&buf); err == nil || !strings.HasPrefix(err.Error(), ErrorValueTooLarge) {
+		t.Errorf("ReadFrom: got %v, want %s", err, ErrorValueTooLarge)
+	}
+	if m.Len() != 0 {
+		t.Errorf("Len: got %d, want 0", m.Len())
+	}
+}
@@ -0,0 +1,72 @@
+package cachemap
+
+import "errors"
+
+// Map 在某一时刻的只读副本, 之后对 Map 的修改不会影响它, 读取时无需加锁
+// 副本保存了所有未过期键值对的 CacheItem, 内存占用与键值对数量成正比, 但值本身不会被深拷贝
+type CacheSnapshot struct {
+	cm      *cacheMap
+	m       map[interface{}]CacheItem
+	aliases map[interface{}]interface{}
+}
+
+func (cm *cacheMap) snapshot() *CacheSnapshot {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	now := cm.now()
+	s := &CacheSnapshot{
+		cm: cm,
+		m:  make(map[interface{}]CacheItem, len(cm.m)),
+	}
+	for k, v := range cm.m {
+		if cm.stateAt(k, v, now) != EntryExpired {
+			s.m[k] = cm.view(v)
+		}
+	}
+	if len(cm.aliases) > 0 {
+		s.aliases = make(map[interface{}]interface{}, len(cm.aliases))
+		for a, k := range cm.aliases {
+			s.aliases[a] = k
+		}
+	}
+	return s
+}
+
+// 在读锁内复制所有未过期的键值对, 返回的副本不随 Map 的修改或过期而变化
+func (w *Map) Snapshot() *CacheSnapshot {
+	return w.snapshot()
+}
+
+// 获取一个键值对, 键的处理方式与 Map.Get 相同, 但不会再判断是否过期
+func (s *CacheSnapshot) Get(key interface{}) (CacheItem, error) {
+	_, k, err := s.cm.rawKey(key)
+	if err != nil {
+		return CacheItem{}, err
+	}
+	if p, ok := s.aliases[k]; ok {
+		k = p
+	}
+	item, ok := s.m[k]
+	if !ok {
+		return CacheItem{}, errors.New(ErrorKeyNotFound)
+	}
+	return item, nil
+}
+
+// 判断创建副本时键是否存在
+func (s *CacheSnapshot) Has(key interface{}) bool {
+	_, err := s.Get(key)
+	return err == nil
+}
+
+// 副本中键值对的数量
+func (s *CacheSnapshot) Len() int {
+	return len(s.m)
+}
+
+// 遍历副本
+func (s *CacheSnapshot) Foreach(fn CallFuncType) {
+	for _, v := range s.m {
+		fn(v)
+	}
+}
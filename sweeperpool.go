@@ -0,0 +1,103 @@
+package cachemap
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 清理协程池, 由多个 Map 共享固定数量的清理协程
+// 每个 Map 仍按各自的 SleepTime 清理, 实际间隔会有最多一个调度周期的误差
+type SweeperPool struct {
+	lock     sync.Mutex
+	maps     map[*cacheMap]time.Time
+	jobs     chan *cacheMap
+	stopChan chan struct{}
+	stopOnce sync.Once
+	tick     time.Duration
+}
+
+// 创建一个清理协程池, workers 为清理协程数量, tick 为调度周期
+func NewSweeperPool(workers int, tick time.Duration) *SweeperPool {
+	if workers <= 0 {
+		workers = 1
+	}
+	if tick <= 0 {
+		tick = 100 * time.Millisecond
+	}
+	p := &SweeperPool{
+		maps:     make(map[*cacheMap]time.Time),
+		jobs:     make(chan *cacheMap, workers),
+		stopChan: make(chan struct{}),
+		tick:     tick,
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	go p.dispatch()
+	return p
+}
+
+// 调度时间与 dispatch 中 ticker 给出的时间比较, 因此使用真实时间而不是 Map 的 CoarseClock
+func (p *SweeperPool) register(cm *cacheMap) {
+	next := time.Now().Add(cm.sleepTime)
+	if cm.sweepStartJitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(cm.sweepStartJitter))))
+	}
+	p.lock.Lock()
+	p.maps[cm] = next
+	p.lock.Unlock()
+}
+
+func (p *SweeperPool) unregister(cm *cacheMap) {
+	p.lock.Lock()
+	delete(p.maps, cm)
+	p.lock.Unlock()
+}
+
+func (p *SweeperPool) dispatch() {
+	ticker := time.NewTicker(p.tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case now := <-ticker.C:
+			due := make([]*cacheMap, 0)
+			p.lock.Lock()
+			for cm, next := range p.maps {
+				if !next.After(now) {
+					due = append(due, cm)
+					p.maps[cm] = now.Add(cm.sleepTime)
+				}
+			}
+			p.lock.Unlock()
+			for _, cm := range due {
+				select {
+				case <-p.stopChan:
+					return
+				case p.jobs <- cm:
+				}
+			}
+		}
+	}
+}
+
+func (p *SweeperPool) work() {
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case cm := <-p.jobs:
+			// 已停止的 Map 不会再被清理, 见 sweep
+			cm.tick()
+		}
+	}
+}
+
+// 停止清理协程池, 已注册的 Map 将不再被清理
+func (p *SweeperPool) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+	})
+}
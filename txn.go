@@ -0,0 +1,55 @@
+package cachemap
+
+import (
+	"time"
+)
+
+// 事务句柄, 仅在 Transaction 的回调函数内有效, 所有操作都在已持有的写锁下执行
+type Txn struct {
+	cm *cacheMap
+}
+
+// 获取一个键值对信息
+func (tx Txn) Get(key interface{}) (CacheItem, error) {
+	return tx.cm.getLocked(key)
+}
+
+// 设置键值对, 键不存在时添加, 存在时更新值和 TTL 并重置 UpdateTime, 保留原有唤醒函数
+// 设置了 Validator 或 TTLFunc 时会在锁内调用, 返回值的含义同 Map.Set
+func (tx Txn) Set(key, value interface{}, ttl time.Duration) (prev CacheItem, replaced bool, err error) {
+	cm := tx.cm
+	if err := cm.checkValue(key, value); err != nil {
+		return CacheItem{}, false, err
+	}
+	ttl = cm.ttlFor(key, value, ttl)
+	value, err = cm.encodeValue(value)
+	if err != nil {
+		return CacheItem{}, false, err
+	}
+	key, k, err := cm.writeKey(key)
+	if err != nil {
+		return CacheItem{}, false, err
+	}
+	prev, replaced = cm.setLocked(key, k, value, ttl, nil, true)
+	return prev, replaced, nil
+}
+
+// 删除一个键值对
+func (tx Txn) Del(key interface{}) error {
+	return tx.cm.delLocked(key)
+}
+
+func (cm *cacheMap) transaction(fn func(tx Txn) error) error {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	defer cm.flushBatchLocked()
+	cm.enterCallback()
+	defer cm.leaveCallback()
+	return fn(Txn{cm: cm})
+}
+
+// 在写锁内执行 fn, 期间其他操作均被阻塞, 保证多个键的操作互斥执行
+// 注意: 事务不会回滚, fn 返回错误时此前已执行的修改依然生效
+func (w *Map) Transaction(fn func(tx Txn) error) error {
+	return w.transaction(fn)
+}